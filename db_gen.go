@@ -42,16 +42,51 @@ func CreateOrAlterTables(structObj *structToCreate, db *sql.DB, group string) {
 	var err error
 	var indexes []string
 	var indexNames []string
-	var primCol string
+	var uniques []string
+	var primCols []string
+	var sequenced bool
+
+	//[enum] types have to exist before the table
+	for _, enum := range structObj.EnumTypes() {
+		if err = CreateOrAlterEnum(enum, db, group); err != nil {
+			log.Printf("\nIssue creating or altering the enum type %s: %s\n", enum.dbName, err.Error())
+			return
+		}
+	}
 
 	//find values for needed variables
 	for _, col := range structObj.cols {
 		if col.primary {
-			primCol = col.colName
+			primCols = append(primCols, col.colName)
+			sequenced = col.Sequenced()
+		}
+	}
+	//a composite key is listed column by column in its constraint
+	primCol := strings.Join(primCols, ", ")
+	for _, key := range structObj.IndexKeys() {
+		indexNames = append(indexNames, fmt.Sprintf("ix_%s_%s", structObj.tableName, key.name))
+		indexes = append(indexes, BuildIndexStmt(structObj, key, tablePathName))
+	}
+
+	//Unique constraints are added with the primary key once any old data is copied
+	for _, key := range structObj.UniqueKeys() {
+		var keyCols []string
+		for _, col := range key.cols {
+			keyCols = append(keyCols, col.colName)
 		}
-		if col.index {
-			indexNames = append(indexNames, fmt.Sprintf("ix_%s_%s", structObj.tableName, col.colName))
-			indexes = append(indexes, fmt.Sprintf("CREATE INDEX ix_%s_%s ON %s USING btree (%s);", structObj.tableName, col.colName, tablePathName, col.colName))
+		indexNames = append(indexNames, fmt.Sprintf("uq_%s_%s", structObj.tableName, key.name))
+		uniques = append(uniques, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT uq_%s_%s UNIQUE (%s);", tablePathName, structObj.tableName, key.name, strings.Join(keyCols, ", ")))
+	}
+
+	//Upsert() needs a unique index matching its ON CONFLICT columns unless they are just the primary key
+	if structObj.hasUpsert {
+		var conflictCols []string
+		for _, col := range structObj.UpsertConflictCols() {
+			conflictCols = append(conflictCols, col.colName)
+		}
+		if strings.Join(conflictCols, ", ") != primCol {
+			indexNames = append(indexNames, fmt.Sprintf("ux_%s_%s", structObj.tableName, strings.Join(conflictCols, "_")))
+			indexes = append(indexes, fmt.Sprintf("CREATE UNIQUE INDEX ux_%s_%s ON %s USING btree (%s);", structObj.tableName, strings.Join(conflictCols, "_"), tablePathName, strings.Join(conflictCols, ", ")))
 		}
 	}
 
@@ -111,7 +146,7 @@ func CreateOrAlterTables(structObj *structToCreate, db *sql.DB, group string) {
 	//Check and rename old primary key constraint if needed
 	pgClassStmt := "SELECT EXISTS(SELECT relname FROM pg_class WHERE relname = $1)"
 	loop = true
-	pkConstraint := fmt.Sprintf("pk_%s_%s", structObj.tableName, primCol)
+	pkConstraint := fmt.Sprintf("pk_%s_%s", structObj.tableName, strings.Join(primCols, "_"))
 	pkRename := pkConstraint
 	for i := 1; loop; i++ {
 		row = db.QueryRow(pgClassStmt, pkRename)
@@ -134,9 +169,10 @@ func CreateOrAlterTables(structObj *structToCreate, db *sql.DB, group string) {
 		}
 	}
 
-	//Check if sequence exists, then rename it if needed
-	loop = true
-	seqName := fmt.Sprintf("%s_%s_seq", structObj.tableName, primCol)
+	//Check if sequence exists, then rename it if needed, identity columns name their own
+	useSeq := sequenced && !structObj.identity
+	loop = useSeq
+	seqName := fmt.Sprintf("%s_%s_seq", structObj.tableName, strings.Join(primCols, "_"))
 	seqRename := seqName
 	for i := 1; loop; i++ {
 		row = db.QueryRow(pgClassStmt, seqRename)
@@ -188,17 +224,14 @@ func CreateOrAlterTables(structObj *structToCreate, db *sql.DB, group string) {
 	var buffer bytes.Buffer
 	buffer.WriteString(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (", tablePathName))
 	for i, col := range structObj.cols {
-		buffer.WriteString(fmt.Sprintf("%s %s ", col.colName, col.dbType))
-		if !col.nulls || col.primary {
-			buffer.WriteString("NOT NULL")
-		}
-		if col.deleted {
-			buffer.WriteString(" DEFAULT false")
-		}
+		buffer.WriteString(BuildColumnDef(structObj, col, sequenced))
 		if i < len(structObj.cols)-1 {
 			buffer.WriteString(", ")
 		}
 	}
+	for i, check := range structObj.checks {
+		buffer.WriteString(fmt.Sprintf(", CONSTRAINT ck_%s_%d CHECK (%s)", structObj.tableName, i+1, check))
+	}
 	buffer.WriteString(" ) WITH (OIDS=FALSE);")
 	_, err = db.Exec(buffer.String())
 	if err != nil {
@@ -219,14 +252,23 @@ func CreateOrAlterTables(structObj *structToCreate, db *sql.DB, group string) {
 	lastSequence := 1
 	copyData := true
 	if oldTableName != "" {
-		selectFromOld := fmt.Sprintf("SELECT %s FROM %s", strings.Join(structObj.oldAltCols, ", "), oldTableName)
+		//old text columns are cast to the [enum] types of their new columns
+		oldCols := append([]string(nil), structObj.oldAltCols...)
+		for i, newCol := range structObj.newAltCols {
+			for _, col := range structObj.cols {
+				if col.colName == newCol && col.enum != nil {
+					oldCols[i] = fmt.Sprintf("%s::text::%s", oldCols[i], col.dbType)
+				}
+			}
+		}
+		selectFromOld := fmt.Sprintf("SELECT %s FROM %s", strings.Join(oldCols, ", "), oldTableName)
 		insertToNew := fmt.Sprintf("INSERT INTO %s (%s) (%s)", tablePathName, strings.Join(structObj.newAltCols, ", "), selectFromOld)
 		_, err = db.Exec(insertToNew)
 		if err != nil {
 			log.Printf("\nIssue copying data from %s to %s: %s\n", oldTableName, tablePathName, err.Error())
 			copyData = false
 		}
-		if structObj.oldColPrim != "" && copyData {
+		if structObj.oldColPrim != "" && copyData && sequenced {
 			//make sure old table has rows.
 			numRows := 1
 			row = db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", oldTableName))
@@ -255,18 +297,45 @@ func CreateOrAlterTables(structObj *structToCreate, db *sql.DB, group string) {
 		return
 	}
 
-	//Create and add sequence to primary key
-	_, err = db.Exec(fmt.Sprintf("CREATE SEQUENCE %s INCREMENT 1 MINVALUE 1 MAXVALUE 9223372036854775807 START %d CACHE 1; ALTER TABLE %s OWNER to %s; GRANT ALL ON TABLE %s TO %s;", seqName, lastSequence, seqName, group, seqName, group))
-	if err != nil {
-		log.Println("\nCreating the primary key sequence failed: " + err.Error() + "\n")
-		return
+	//Add unique constraints, copied data that isn't unique leaves the constraint off
+	for _, stmt := range uniques {
+		_, err = db.Exec(stmt)
+		if err != nil {
+			log.Println("\nCreating a unique constraint failed: " + err.Error() + "\n")
+		}
 	}
 
-	//Bind sequence to primary key column as its defualt value
-	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT nextval('%s'::regclass);", tablePathName, primCol, seqName))
-	if err != nil {
-		log.Println("\nBinding the default primary key sequence failed: " + err.Error() + "\n")
-		return
+	//Foreign keys of other tables followed the old table when it was renamed, point them at the new table
+	//now that its keys exist, before the steps below that can stop early
+	if renameTable != structObj.tableName {
+		RepointForeignKeys(db, fmt.Sprintf("%s.%s", AddQuotesIfAnyUpperCase(structObj.schema), renameTable), tablePathName)
+	}
+	//[alter table] copied from a table with another name, its foreign keys move to the new table as well
+	if structObj.actionType != "Add" && structObj.actionType != structObj.tableName {
+		RepointForeignKeys(db, fmt.Sprintf("%s.%s", AddQuotesIfAnyUpperCase(structObj.schema), structObj.actionType), tablePathName)
+	}
+
+	//Create and add sequence to primary key, [uuid] and natural keys don't use one
+	if useSeq {
+		_, err = db.Exec(fmt.Sprintf("CREATE SEQUENCE %s INCREMENT 1 MINVALUE 1 MAXVALUE 9223372036854775807 START %d CACHE 1; ALTER TABLE %s OWNER to %s; GRANT ALL ON TABLE %s TO %s;", seqName, lastSequence, seqName, group, seqName, group))
+		if err != nil {
+			log.Println("\nCreating the primary key sequence failed: " + err.Error() + "\n")
+			return
+		}
+
+		//Bind sequence to primary key column as its defualt value
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT nextval('%s'::regclass);", tablePathName, primCol, seqName))
+		if err != nil {
+			log.Println("\nBinding the default primary key sequence failed: " + err.Error() + "\n")
+			return
+		}
+	} else if sequenced && lastSequence > 1 {
+		//an identity column owns its sequence, it only has to continue after the copied keys
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s RESTART WITH %d;", tablePathName, primCol, lastSequence))
+		if err != nil {
+			log.Println("\nRestarting the primary key identity failed: " + err.Error() + "\n")
+			return
+		}
 	}
 
 	//Loop and add indexes if needed
@@ -279,3 +348,231 @@ func CreateOrAlterTables(structObj *structToCreate, db *sql.DB, group string) {
 	}
 
 }
+
+// BuildColumnDef builds a column's definition for CREATE TABLE, sequenced is
+// true when the primary key takes its values from a sequence or identity
+func BuildColumnDef(structObj *structToCreate, col *column, sequenced bool) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("%s %s ", col.colName, col.dbType))
+	//a nil slice or net.IP is stored as NULL
+	if (!col.nulls && col.ValueKind() != "array" && col.ValueKind() != "inet") || col.primary {
+		buffer.WriteString("NOT NULL")
+	}
+	if col.deleted {
+		buffer.WriteString(" DEFAULT false")
+	}
+	if col.version {
+		buffer.WriteString(" DEFAULT 1")
+	}
+	if col.createdOn || col.updatedOn {
+		buffer.WriteString(" DEFAULT now()")
+	}
+	if col.defaultExpr != "" {
+		buffer.WriteString(" DEFAULT " + col.defaultExpr)
+	}
+	if col.GeneratedKey() {
+		buffer.WriteString(" DEFAULT gen_random_uuid()")
+	}
+	if col.primary && sequenced && structObj.identity {
+		buffer.WriteString(" GENERATED BY DEFAULT AS IDENTITY")
+	}
+	if col.check != "" {
+		buffer.WriteString(fmt.Sprintf(" CONSTRAINT ck_%s_%s CHECK (%s)", structObj.tableName, col.colName, col.check))
+	}
+	return buffer.String()
+}
+
+// BuildIndexStmt builds the CREATE INDEX statement for an [index] column or an
+// [index:group], with its [using], [desc] and [partial] options
+func BuildIndexStmt(structObj *structToCreate, key indexKey, tablePathName string) string {
+	var delColName string
+	for _, col := range structObj.cols {
+		if col.deleted {
+			delColName = col.colName
+		}
+	}
+	var keyCols []string
+	for _, col := range key.cols {
+		if col.indexDesc {
+			keyCols = append(keyCols, col.colName+" DESC")
+		} else {
+			keyCols = append(keyCols, col.colName)
+		}
+	}
+	create := "CREATE INDEX"
+	if structObj.concurrently {
+		create = "CREATE INDEX CONCURRENTLY"
+	}
+	stmt := fmt.Sprintf("%s ix_%s_%s ON %s USING %s (%s)", create, structObj.tableName, key.name, tablePathName, key.Using(), strings.Join(keyCols, ", "))
+	if where := key.Where(delColName); where != "" {
+		stmt += " WHERE " + where
+	}
+	return stmt + ";"
+}
+
+// AddForeignKeys adds the constraints for [references] columns. It is called
+// after all of the tables in the file have been created.
+func AddForeignKeys(structObj *structToCreate, db *sql.DB) {
+	var tablePathName string = fmt.Sprintf("%s.%s.%s", AddQuotesIfAnyUpperCase(structObj.database), AddQuotesIfAnyUpperCase(structObj.schema), structObj.tableName)
+	for _, col := range structObj.cols {
+		if col.refTable == "" {
+			continue
+		}
+		fkStmt := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT fk_%s_%s FOREIGN KEY (%s) REFERENCES %s.%s.%s (%s)", tablePathName, structObj.tableName, col.colName, col.colName, AddQuotesIfAnyUpperCase(structObj.database), AddQuotesIfAnyUpperCase(structObj.schema), col.refTable, col.refColumn)
+		if col.onDelete != "" {
+			fkStmt += " ON DELETE " + col.onDelete
+		}
+		if col.onUpdate != "" {
+			fkStmt += " ON UPDATE " + col.onUpdate
+		}
+		_, err := db.Exec(fkStmt + ";")
+		if err != nil {
+			log.Printf("\nCreating the foreign key on %s.%s failed: %s\n", structObj.tableName, col.colName, err.Error())
+		}
+	}
+}
+
+// CreateJoinTable creates the table for a [many to many] line. An existing join
+// table is left alone since it only holds keys.
+func CreateJoinTable(join *manyToMany, db *sql.DB, group string) {
+	first, second := join.structs[0], join.structs[1]
+	var tablePathName string = fmt.Sprintf("%s.%s.%s", AddQuotesIfAnyUpperCase(first.database), AddQuotesIfAnyUpperCase(first.schema), join.tableName)
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT * FROM information_schema.tables WHERE table_name =  $1 and table_schema = $2)", join.tableName, first.schema).Scan(&exists)
+	if err != nil {
+		log.Println("\nAn error occurred checking for the join table's existence :" + err.Error() + "\n")
+		return
+	}
+	if exists {
+		fmt.Printf("\nThe join table %s already exists and was left as is.\n", join.tableName)
+		return
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("CREATE TABLE %s (", tablePathName))
+	for i, struc := range join.structs {
+		buffer.WriteString(fmt.Sprintf("%s %s NOT NULL, ", join.colNames[i], struc.PrimaryCol().dbType))
+	}
+	buffer.WriteString(fmt.Sprintf("CONSTRAINT pk_%s PRIMARY KEY (%s, %s)", join.tableName, join.colNames[0], join.colNames[1]))
+	for i, struc := range join.structs {
+		buffer.WriteString(fmt.Sprintf(", CONSTRAINT fk_%s_%s FOREIGN KEY (%s) REFERENCES %s.%s.%s (%s) ON DELETE CASCADE", join.tableName, join.colNames[i], join.colNames[i], AddQuotesIfAnyUpperCase(struc.database), AddQuotesIfAnyUpperCase(struc.schema), struc.tableName, struc.PrimaryCol().colName))
+	}
+	buffer.WriteString(" ) WITH (OIDS=FALSE);")
+	//the primary key covers lookups by the first column, index the second for the reverse
+	buffer.WriteString(fmt.Sprintf(" CREATE INDEX ix_%s_%s ON %s USING btree (%s);", join.tableName, join.colNames[1], tablePathName, join.colNames[1]))
+	buffer.WriteString(fmt.Sprintf(" ALTER TABLE %s OWNER to %s; GRANT ALL ON TABLE %s TO %s;", tablePathName, group, tablePathName, group))
+	_, err = db.Exec(buffer.String())
+	if err != nil {
+		log.Printf("\nCreating the join table %s between %s and %s failed: %s\n", join.tableName, first.tableName, second.tableName, err.Error())
+	}
+}
+
+// CreateOrAlterEnum creates the Postgres type of an [enum], or adds the values
+// the type is missing when it already exists
+func CreateOrAlterEnum(enum *enumType, db *sql.DB, group string) error {
+	rows, err := db.Query("SELECT e.enumlabel FROM pg_enum e JOIN pg_type t ON t.oid = e.enumtypid JOIN pg_namespace n ON n.oid = t.typnamespace WHERE t.typname = $1 and n.nspname = $2 ORDER BY e.enumsortorder", enum.dbName, enum.schema)
+	if err != nil {
+		return err
+	}
+	var existing []string
+	for rows.Next() {
+		var label string
+		if err = rows.Scan(&label); err != nil {
+			rows.Close()
+			return err
+		}
+		existing = append(existing, label)
+	}
+	rows.Close()
+	if len(existing) == 0 {
+		_, err = db.Exec(fmt.Sprintf("CREATE TYPE %s AS ENUM ('%s'); ALTER TYPE %s OWNER TO %s;", enum.QualifiedName(), strings.Join(enum.values, "', '"), enum.QualifiedName(), group))
+		return err
+	}
+	//ADD VALUE can't run in a transaction block, so each one is sent on its own
+	stmts, dropped := EnumAlterStmts(enum, existing)
+	for _, stmt := range stmts {
+		if _, err = db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if len(dropped) > 0 {
+		fmt.Printf("\nPostgres can't remove enum values, so %s still has %s.\n", enum.dbName, strings.Join(dropped, ", "))
+	}
+	return nil
+}
+
+// EnumAlterStmts returns the ALTER TYPE statements adding the values of an
+// [enum] that the existing type doesn't have, each placed after the value
+// before it in the [enum] line. It also returns the existing values that are
+// no longer listed.
+func EnumAlterStmts(enum *enumType, existing []string) ([]string, []string) {
+	var stmts []string
+	var dropped []string
+	listed := make(map[string]bool)
+	for _, value := range enum.values {
+		listed[value] = true
+	}
+	have := make(map[string]bool)
+	for _, value := range existing {
+		have[value] = true
+		if !listed[value] {
+			dropped = append(dropped, value)
+		}
+	}
+	for i, value := range enum.values {
+		if have[value] {
+			continue
+		}
+		position := ""
+		if i > 0 {
+			position = fmt.Sprintf(" AFTER '%s'", enum.values[i-1])
+		} else if len(existing) > 0 {
+			position = fmt.Sprintf(" BEFORE '%s'", existing[0])
+		}
+		stmts = append(stmts, fmt.Sprintf("ALTER TYPE %s ADD VALUE IF NOT EXISTS '%s'%s;", enum.QualifiedName(), value, position))
+		have[value] = true
+	}
+	return stmts, dropped
+}
+
+// RepointForeignKeys moves foreign keys that reference oldTable (other than its
+// own) over to newTable, keeping their names, columns and actions
+func RepointForeignKeys(db *sql.DB, oldTable string, newTable string) {
+	rows, err := db.Query("SELECT conname, conrelid::regclass::text, pg_get_constraintdef(oid) FROM pg_constraint WHERE contype = 'f' and confrelid = $1::regclass and conrelid <> confrelid", oldTable)
+	if err != nil {
+		log.Println("\nAn error occurred finding the foreign keys that reference the old table: " + err.Error() + "\n")
+		return
+	}
+	var fks [][]string
+	for rows.Next() {
+		var name, table, def string
+		if err = rows.Scan(&name, &table, &def); err != nil {
+			log.Println("\nAn error occurred reading a foreign key: " + err.Error() + "\n")
+			rows.Close()
+			return
+		}
+		fks = append(fks, []string{name, table, RepointConstraintDef(def, newTable)})
+	}
+	rows.Close()
+	for _, fk := range fks {
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s; ALTER TABLE %s ADD CONSTRAINT %s %s;", fk[1], fk[0], fk[1], fk[0], fk[2]))
+		if err != nil {
+			log.Printf("\nThe foreign key %s on %s couldn't be moved to %s: %s\n", fk[0], fk[1], newTable, err.Error())
+		}
+	}
+}
+
+// RepointConstraintDef replaces the referenced table in a foreign key definition
+// from pg_get_constraintdef, e.g. "FOREIGN KEY (a) REFERENCES t1(b) ON DELETE CASCADE"
+func RepointConstraintDef(def string, newTable string) string {
+	start := strings.Index(def, " REFERENCES ")
+	if start < 0 {
+		return def
+	}
+	start += len(" REFERENCES ")
+	end := strings.Index(def[start:], "(")
+	if end < 0 {
+		return def
+	}
+	return def[:start] + newTable + def[start+end:]
+}
@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestRepointConstraintDef(t *testing.T) {
+	tests := []struct {
+		def  string
+		want string
+	}{
+		{"FOREIGN KEY (user_id) REFERENCES users1(login_id) ON DELETE CASCADE", "FOREIGN KEY (user_id) REFERENCES db.public.users(login_id) ON DELETE CASCADE"},
+		{"FOREIGN KEY (user_id) REFERENCES public.users1(login_id)", "FOREIGN KEY (user_id) REFERENCES db.public.users(login_id)"},
+		{"FOREIGN KEY (author_id) REFERENCES old_users(login_id) ON UPDATE CASCADE", "FOREIGN KEY (author_id) REFERENCES db.public.users(login_id) ON UPDATE CASCADE"},
+		{"CHECK ((id > 0))", "CHECK ((id > 0))"},
+	}
+	for _, tt := range tests {
+		if got := RepointConstraintDef(tt.def, "db.public.users"); got != tt.want {
+			t.Errorf("RepointConstraintDef(%q) = %q, want %q", tt.def, got, tt.want)
+		}
+	}
+}
+
+func TestBuildIndexStmt(t *testing.T) {
+	name := &column{colName: "name", index: true, partial: true}
+	title := &column{colName: "title", index: true, indexUsing: "hash"}
+	author := &column{colName: "author_id", indexGroups: []string{"recent"}}
+	posted := &column{colName: "posted", indexGroups: []string{"recent"}, indexDesc: true, partial: true, where: "posted > '2020-01-01'"}
+	s := &structToCreate{tableName: "blog", cols: []*column{name, title, author, posted, {colName: "deleted", deleted: true}}}
+	want := []string{
+		"CREATE INDEX ix_blog_name ON db.public.blog USING btree (name) WHERE deleted = false;",
+		"CREATE INDEX ix_blog_title ON db.public.blog USING hash (title);",
+		"CREATE INDEX ix_blog_recent ON db.public.blog USING btree (author_id, posted DESC) WHERE posted > '2020-01-01';",
+	}
+	keys := s.IndexKeys()
+	if len(keys) != len(want) {
+		t.Fatalf("IndexKeys() returned %d keys, want %d", len(keys), len(want))
+	}
+	for i, key := range keys {
+		if got := BuildIndexStmt(s, key, "db.public.blog"); got != want[i] {
+			t.Errorf("BuildIndexStmt(%s) = %q, want %q", key.name, got, want[i])
+		}
+	}
+	s.concurrently = true
+	if got := BuildIndexStmt(s, keys[1], "db.public.blog"); got != "CREATE INDEX CONCURRENTLY ix_blog_title ON db.public.blog USING hash (title);" {
+		t.Errorf("BuildIndexStmt with [concurrent indexes] = %q", got)
+	}
+}
+
+func TestEnumAlterStmts(t *testing.T) {
+	enum := &enumType{dbName: "order_status", schema: "public", values: []string{"draft", "pending", "on_hold", "shipped"}}
+	stmts, dropped := EnumAlterStmts(enum, []string{"pending", "shipped", "lost"})
+	want := []string{
+		"ALTER TYPE public.order_status ADD VALUE IF NOT EXISTS 'draft' BEFORE 'pending';",
+		"ALTER TYPE public.order_status ADD VALUE IF NOT EXISTS 'on_hold' AFTER 'pending';",
+	}
+	if len(stmts) != len(want) {
+		t.Fatalf("EnumAlterStmts() returned %q, want %q", stmts, want)
+	}
+	for i := range want {
+		if stmts[i] != want[i] {
+			t.Errorf("EnumAlterStmts()[%d] = %q, want %q", i, stmts[i], want[i])
+		}
+	}
+	if len(dropped) != 1 || dropped[0] != "lost" {
+		t.Errorf("EnumAlterStmts() dropped %q, want [lost]", dropped)
+	}
+}
+
+func TestBuildColumnDef(t *testing.T) {
+	s := &structToCreate{tableName: "host"}
+	tests := []struct {
+		col  *column
+		want string
+	}{
+		{&column{colName: "addr", goType: "net.IP", dbType: "inet"}, "addr inet "},
+		{&column{colName: "tags", goType: "[]string", dbType: "text[]"}, "tags text[] "},
+		{&column{colName: "name", goType: "string", dbType: "text"}, "name text NOT NULL"},
+		{&column{colName: "uptime", goType: "time.Duration", dbType: "interval"}, "uptime interval NOT NULL"},
+	}
+	for _, tt := range tests {
+		if got := BuildColumnDef(s, tt.col, false); got != tt.want {
+			t.Errorf("BuildColumnDef(%s) = %q, want %q", tt.col.colName, got, tt.want)
+		}
+	}
+}
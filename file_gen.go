@@ -51,7 +51,283 @@ func GetSafePathForSave(filePath string) string {
 	}
 }
 
-func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, packageName string) string {
+// BuildFileHeader writes the package and the imports needed by every struct generated to the file
+func BuildFileHeader(fileStructs []*structToCreate, packageName string) string {
+	var buffer bytes.Buffer
+	//discover if the time, nulls and bytes packages need to be included
+	time := "\n"
+	nulls := ""
+	bytesPkg := ""
+	errorsPkg := ""
+	uuidPkg := ""
+	driverPkg := ""
+	netPkg := ""
+	reflectPkg := ""
+	decimalPkg := ""
+	for _, structFromFile := range fileStructs {
+		if structFromFile.VersionCol() != nil {
+			errorsPkg = "\n\"errors\""
+		}
+		for _, col := range structFromFile.cols {
+			if (col.deletedOn && !col.nulls) || col.goType == "time.Time" || col.baseType == "time.Time" || col.goType == "time.Duration" {
+				time = "\n\"time\"\n"
+			}
+			if structFromFile.trackChanges && col.ArgType() == "[]byte" {
+				bytesPkg = "\n\"bytes\""
+			}
+			if col.ArgType() == "uuid.UUID" {
+				uuidPkg = "\n\"github.com/google/uuid\""
+			}
+			//a column flattened from an embedded [nested struct] only names its type in method parameters and ApplyJSONPatch
+			if col.embed == nil || col.InMethodParams() || col.JSONKey() != "" {
+				if col.ArgType() == "decimal.Decimal" {
+					decimalPkg = "\n\"github.com/shopspring/decimal\""
+				}
+				if strings.HasPrefix(col.goType, "nulls.") {
+					nulls = "\"github.com/markbates/going/nulls\""
+				}
+			}
+			//jsonb, interval and inet variables go through a converter with Scan and Value methods
+			switch col.ValueKind() {
+			case "jsonb":
+				driverPkg = "\n\"database/sql/driver\""
+				reflectPkg = "\n\"reflect\""
+			case "inet":
+				driverPkg = "\n\"database/sql/driver\""
+				netPkg = "\n\"net\""
+				reflectPkg = "\n\"reflect\""
+			case "interval":
+				driverPkg = "\n\"database/sql/driver\""
+				reflectPkg = "\n\"reflect\""
+			case "array":
+				if structFromFile.trackChanges {
+					reflectPkg = "\n\"reflect\""
+				}
+			}
+			if col.customType && structFromFile.trackChanges {
+				reflectPkg = "\n\"reflect\""
+			}
+		}
+		//[enum] types send their values through a driver.Valuer
+		if len(structFromFile.enums) > 0 {
+			driverPkg = "\n\"database/sql/driver\""
+		}
+		//the [nested struct] types written with the struct need the packages of their variables
+		for _, nested := range structFromFile.nestedTypes {
+			for _, col := range nested.cols {
+				switch {
+				case col.embed != nil:
+					//written as the embedded type
+				case col.ArgType() == "time.Time" || col.goType == "time.Duration":
+					time = "\n\"time\"\n"
+				case col.goType == "net.IP":
+					netPkg = "\n\"net\""
+				case col.ArgType() == "uuid.UUID":
+					uuidPkg = "\n\"github.com/google/uuid\""
+				case col.ArgType() == "decimal.Decimal":
+					decimalPkg = "\n\"github.com/shopspring/decimal\""
+				case strings.HasPrefix(col.goType, "nulls."):
+					nulls = "\"github.com/markbates/going/nulls\""
+				}
+			}
+		}
+	}
+	buffer.WriteString("package ")
+	buffer.WriteString(packageName)
+	buffer.WriteString("\n\n")
+	buffer.WriteString("import (\n")
+	buffer.WriteString("\"database/sql\"\n//DB Driver\n\"github.com/lib/pq\"\n\"context\"\n\"encoding/json\"\n\"fmt\"\n\"log\"\n\"strconv\"\n\"strings\"")
+	buffer.WriteString(bytesPkg)
+	buffer.WriteString(errorsPkg)
+	buffer.WriteString(driverPkg)
+	buffer.WriteString(netPkg)
+	buffer.WriteString(reflectPkg)
+	buffer.WriteString(time)
+	buffer.WriteString(nulls)
+	buffer.WriteString(uuidPkg)
+	buffer.WriteString(decimalPkg)
+	buffer.WriteString("\n)\n")
+	return buffer.String()
+}
+
+// BuildStructFields writes the variables of a struct, the columns of an
+// embedded [nested struct] are written as the one embedded type
+func BuildStructFields(cols []*column) string {
+	var buffer bytes.Buffer
+	embedded := make(map[*structToCreate]bool)
+	for _, col := range cols {
+		if col.embed != nil {
+			if !embedded[col.embed] {
+				buffer.WriteString(col.embed.structName + "\n")
+				embedded[col.embed] = true
+			}
+			continue
+		}
+		buffer.WriteString(col.structLine)
+		buffer.WriteString("\n")
+	}
+	return buffer.String()
+}
+
+// BuildApplyJSONPatch writes the ApplyJSONPatch method of a struct and, when it
+// has jsonb variables, the function merging a patch into their JSON. Each key is
+// read into a fresh value, so a patch that fails leaves the struct untouched.
+func BuildApplyJSONPatch(structFromFile *structToCreate, structObject string) string {
+	var buffer bytes.Buffer
+	var patchCols []string
+	var notPatchable []string
+	for _, col := range structFromFile.PrimaryCols() {
+		notPatchable = append(notPatchable, col.varName)
+	}
+	for _, col := range structFromFile.cols {
+		if col.DBManaged() {
+			notPatchable = append(notPatchable, col.varName)
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("//Apply a JSON merge patch to the %s and update only the columns it touches, returns the updated columns\n//Keys must match the struct's json tags, %s can't be patched\n", structFromFile.structName, strings.Join(notPatchable, ", ")))
+	buffer.WriteString(fmt.Sprintf("func (%s *%s) ApplyJSONPatch(patch []byte) ([]string, error) {\nvar fields map[string]json.RawMessage\nif err := json.Unmarshal(patch, &fields); err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\n", structObject, structFromFile.structName))
+	mergeJSON := false
+	buffer.WriteString(fmt.Sprintf("//apply to a copy so a bad key or value leaves the %s untouched\npatched := *%s\ntouched := make(map[string]bool)\nfor key, value := range fields {\nvar err error\nswitch key {\n", structFromFile.structName, structObject))
+	for _, col := range structFromFile.cols {
+		jsonKey := col.JSONKey()
+		if col.primary || col.DBManaged() || jsonKey == "" {
+			continue
+		}
+		patchCols = append(patchCols, "\""+col.colName+"\"")
+		buffer.WriteString(fmt.Sprintf("case %s:\n", strconv.Quote(jsonKey)))
+		if !col.nulls {
+			buffer.WriteString(fmt.Sprintf("if string(value) == \"null\" {\nreturn nil, fmt.Errorf(\"%s: %%s can't be null\", key)\n}\n", structFromFile.structName))
+		}
+		switch {
+		case col.nullStyle == "sql":
+			buffer.WriteString(fmt.Sprintf("var nullable *%s\nerr = json.Unmarshal(value, &nullable)\n%s", col.baseType, setSQLNull(col, "patched", "nullable")))
+		case col.ValueKind() == "jsonb":
+			//objects are merged into the current value, a null in them removes the key
+			mergeJSON = true
+			buffer.WriteString(fmt.Sprintf("var fresh %s\nvar merged []byte\nmerged, err = %sMergeJSON(patched.%s, value)\nif err == nil {\nerr = json.Unmarshal(merged, &fresh)\n}\npatched.%s = fresh\n", col.goType, structObject, col.varName, col.varName))
+		default:
+			//a fresh value, so maps and slices shared with the original aren't written to
+			buffer.WriteString(fmt.Sprintf("var fresh %s\nerr = json.Unmarshal(value, &fresh)\npatched.%s = fresh\n", col.goType, col.varName))
+		}
+		buffer.WriteString(fmt.Sprintf("touched[\"%s\"] = true\n", col.colName))
+	}
+	buffer.WriteString(fmt.Sprintf("default:\nreturn nil, fmt.Errorf(\"%s: %%s is not a patchable JSON key\", key)\n}\nif err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\n}\n", structFromFile.structName))
+	buffer.WriteString(fmt.Sprintf("var colNames []string\nfor _, colName := range []string{%s} {\nif touched[colName] {\ncolNames = append(colNames, colName)\n}\n}\nif len(colNames) == 0 {\nreturn colNames, nil\n}\n", strings.Join(patchCols, ", ")))
+	buffer.WriteString(fmt.Sprintf("if err := patched.updateColumns(colNames); err != nil {\nreturn nil, err\n}\n*%s = patched\nreturn colNames, nil\n}\n\n", structObject))
+	if mergeJSON {
+		buffer.WriteString(fmt.Sprintf("//%sMergeJSON applies a JSON merge patch to the JSON of a jsonb variable, a null removes the key\nfunc %sMergeJSON(current interface{}, patch []byte) ([]byte, error) {\n", structObject, structObject))
+		buffer.WriteString("//numbers are kept as they are written\nvar patchValue, target interface{}\ndecoder := json.NewDecoder(strings.NewReader(string(patch)))\ndecoder.UseNumber()\nif err := decoder.Decode(&patchValue); err != nil {\nreturn nil, err\n}\nif _, ok := patchValue.(map[string]interface{}); !ok {\n//anything but an object replaces the value\nreturn patch, nil\n}\n")
+		buffer.WriteString("currentJSON, err := json.Marshal(current)\nif err != nil {\nreturn nil, err\n}\ndecoder = json.NewDecoder(strings.NewReader(string(currentJSON)))\ndecoder.UseNumber()\nif err := decoder.Decode(&target); err != nil {\nreturn nil, err\n}\n")
+		buffer.WriteString("var merge func(target interface{}, patch interface{}) interface{}\nmerge = func(target interface{}, patch interface{}) interface{} {\npatchObject, ok := patch.(map[string]interface{})\nif !ok {\nreturn patch\n}\ntargetObject, ok := target.(map[string]interface{})\nif !ok {\ntargetObject = make(map[string]interface{})\n}\nfor key, value := range patchObject {\nif value == nil {\ndelete(targetObject, key)\n} else {\ntargetObject[key] = merge(targetObject[key], value)\n}\n}\nreturn targetObject\n}\nreturn json.Marshal(merge(target, patchValue))\n}\n\n")
+	}
+	return buffer.String()
+}
+
+// BuildValueConverter writes the type passing the jsonb, interval and inet
+// variables of a struct to and from the DB, kinds holds their ValueKind values.
+// NULL is read as the variable's zero value and a nil net.IP is sent as NULL.
+func BuildValueConverter(structName string, valueType string, kinds map[string]bool) string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("//%s passes the jsonb, interval and inet variables of a %s to and from the DB\ntype %s struct {\nv interface{}\n}\n\n", valueType, structName, valueType))
+	buffer.WriteString(fmt.Sprintf("//Scan reads a jsonb, interval or inet column into the variable, NULL leaves it at its zero value\nfunc (value %s) Scan(src interface{}) error {\n", valueType))
+	buffer.WriteString("//clear the variable first so the keys of a reused map don't survive\nreflect.ValueOf(value.v).Elem().Set(reflect.Zero(reflect.TypeOf(value.v).Elem()))\nif src == nil {\nreturn nil\n}\n")
+	if kinds["interval"] {
+		//intervals are selected as nanoseconds
+		buffer.WriteString(fmt.Sprintf("if d, ok := value.v.(*time.Duration); ok {\nn, ok := src.(int64)\nif !ok {\nreturn fmt.Errorf(\"%s: can't scan %%T into %%T\", src, value.v)\n}\n*d = time.Duration(n)\nreturn nil\n}\n", structName))
+	}
+	if kinds["jsonb"] || kinds["inet"] {
+		buffer.WriteString(fmt.Sprintf("b, ok := src.([]byte)\nif !ok {\nreturn fmt.Errorf(\"%s: can't scan %%T into %%T\", src, value.v)\n}\n", structName))
+	}
+	if kinds["inet"] {
+		buffer.WriteString(fmt.Sprintf("if ip, ok := value.v.(*net.IP); ok {\nif *ip = net.ParseIP(string(b)); *ip == nil {\nreturn fmt.Errorf(\"%s: %%q isn't an IP address\", b)\n}\nreturn nil\n}\n", structName))
+	}
+	if kinds["jsonb"] {
+		buffer.WriteString("return json.Unmarshal(b, value.v)\n}\n\n")
+	} else {
+		buffer.WriteString(fmt.Sprintf("return fmt.Errorf(\"%s: can't scan into %%T\", value.v)\n}\n\n", structName))
+	}
+	buffer.WriteString(fmt.Sprintf("//Value converts the variable to a query parameter\nfunc (value %s) Value() (driver.Value, error) {\n", valueType))
+	if kinds["inet"] || kinds["interval"] {
+		buffer.WriteString("switch v := value.v.(type) {\n")
+		if kinds["inet"] {
+			buffer.WriteString("case *net.IP:\n//an unset IP would be sent as \"<nil>\"\nif len(*v) == 0 {\nreturn nil, nil\n}\nreturn v.String(), nil\n")
+		}
+		if kinds["interval"] {
+			buffer.WriteString("case *time.Duration:\nreturn strconv.FormatInt(v.Microseconds(), 10) + \" microseconds\", nil\n")
+		}
+		buffer.WriteString("}\n")
+	}
+	if kinds["jsonb"] {
+		buffer.WriteString("b, err := json.Marshal(value.v)\nreturn string(b), err\n}\n\n")
+	} else {
+		buffer.WriteString(fmt.Sprintf("return nil, fmt.Errorf(\"%s: can't convert %%T\", value.v)\n}\n\n", structName))
+	}
+	return buffer.String()
+}
+
+// BuildSQLNullJSON writes the JSON methods of a struct with [nulls style] sql
+// variables so they read and write their values or null, not the Valid field.
+// The struct is converted to a type without the methods and the variables are
+// shadowed by pointers with the same json tags.
+func BuildSQLNullJSON(structFromFile *structToCreate, structObject string) string {
+	var sqlCols []*column
+	var shadows, fill bytes.Buffer
+	for _, col := range structFromFile.cols {
+		if col.nullStyle != "sql" || col.JSONKey() == "" {
+			continue
+		}
+		sqlCols = append(sqlCols, col)
+		shadows.WriteString(col.varName + " *" + col.baseType)
+		if tagParts := strings.Split(col.structLine, "`"); len(tagParts) > 1 {
+			shadows.WriteString(" `" + tagParts[1] + "`")
+		}
+		shadows.WriteString("\n")
+		fill.WriteString(fmt.Sprintf("if %s.%s.Valid {\nvalue := %s\naux.%s = &value\n}\n", structObject, col.varName, col.ValueExpr(structObject), col.varName))
+	}
+	if len(sqlCols) == 0 {
+		return ""
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("//MarshalJSON writes the database/sql null variables as their values or null\nfunc (%s %s) MarshalJSON() ([]byte, error) {\ntype alias %s\naux := struct {\nalias\n%s}{alias: alias(%s)}\n", structObject, structFromFile.structName, structFromFile.structName, shadows.String(), structObject))
+	buffer.WriteString(fill.String())
+	buffer.WriteString("return json.Marshal(aux)\n}\n\n")
+	buffer.WriteString(fmt.Sprintf("//UnmarshalJSON reads the database/sql null variables from their values or null, missing keys keep their values\nfunc (%s *%s) UnmarshalJSON(b []byte) error {\ntype alias %s\naux := struct {\n*alias\n%s}{alias: (*alias)(%s)}\n", structObject, structFromFile.structName, structFromFile.structName, shadows.String(), structObject))
+	buffer.WriteString(fill.String())
+	buffer.WriteString("if err := json.Unmarshal(b, &aux); err != nil {\nreturn err\n}\n")
+	for _, col := range sqlCols {
+		buffer.WriteString(setSQLNull(col, structObject, "aux."+col.varName))
+	}
+	buffer.WriteString("return nil\n}\n\n")
+	return buffer.String()
+}
+
+// setSQLNull writes the statements setting the [nulls style] sql variable on obj
+// from the pointer ptr, NULL when it is nil
+func setSQLNull(col *column, obj string, ptr string) string {
+	return fmt.Sprintf("%s.%s = %s\nif %s != nil {\n%s, %s.%s.Valid = *%s, true\n}\n", obj, col.varName, col.NullZero(), ptr, col.ValueExpr(obj), obj, col.varName, ptr)
+}
+
+// BuildEnumCode writes the Go type of an [enum] with a constant for each value.
+// Its Value method and UnmarshalJSON reject values that aren't in the enum.
+func BuildEnumCode(enum *enumType) string {
+	var buffer bytes.Buffer
+	var consts []string
+	for _, value := range enum.values {
+		consts = append(consts, enum.ConstName(value))
+	}
+	buffer.WriteString(fmt.Sprintf("\n//%s is stored in the %s enum type\ntype %s string\n\n//%s values\nconst (\n", enum.typeName, enum.dbName, enum.typeName, enum.typeName))
+	for i, value := range enum.values {
+		buffer.WriteString(fmt.Sprintf("%s %s = \"%s\"\n", consts[i], enum.typeName, value))
+	}
+	buffer.WriteString(")\n\n")
+	buffer.WriteString(fmt.Sprintf("//%sValues lists the %s values in the order of the enum type\nvar %sValues = []%s{%s}\n\n", enum.typeName, enum.typeName, enum.typeName, enum.typeName, strings.Join(consts, ", ")))
+	buffer.WriteString(fmt.Sprintf("//Valid reports if value is one of the %s constants\nfunc (value %s) Valid() bool {\nswitch value {\ncase %s:\nreturn true\n}\nreturn false\n}\n\n", enum.typeName, enum.typeName, strings.Join(consts, ", ")))
+	buffer.WriteString(fmt.Sprintf("//Value sends an empty %s as NULL and rejects values that aren't in the enum\nfunc (value %s) Value() (driver.Value, error) {\nif value == \"\" {\nreturn nil, nil\n}\nif !value.Valid() {\nreturn nil, fmt.Errorf(\"%%q isn't a valid %s\", string(value))\n}\nreturn string(value), nil\n}\n\n", enum.typeName, enum.typeName, enum.typeName))
+	buffer.WriteString(fmt.Sprintf("//UnmarshalJSON only accepts the %s values\nfunc (value *%s) UnmarshalJSON(b []byte) error {\nvar s string\nif err := json.Unmarshal(b, &s); err != nil {\nreturn err\n}\nif !%s(s).Valid() {\nreturn fmt.Errorf(\"%%q isn't a valid %s\", s)\n}\n*value = %s(s)\nreturn nil\n}\n", enum.typeName, enum.typeName, enum.typeName, enum.typeName, enum.typeName))
+	return buffer.String()
+}
+
+func BuildStringForFileWrite(structFromFile *structToCreate) string {
 
 	var buffer bytes.Buffer
 	var primColName string
@@ -61,34 +337,19 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 	var delOnColName string
 	var delColType string
 	var delOnColType string
+	var delOnZero string
 	var delVarName string
 	var delOnVarName string
 	var tablePathName string = fmt.Sprintf("%s.%s.%s", AddQuotesIfAnyUpperCase(structFromFile.database), AddQuotesIfAnyUpperCase(structFromFile.schema), structFromFile.tableName)
 	structObject := LowerCaseFirstChar(structFromFile.structName)
 
-	//Write package and imports
-	if isNew {
-		//discover if the time package needs to be included
-		time := "\n"
-		for _, col := range structFromFile.cols {
-			if (col.deletedOn && !col.nulls) || col.goType == "time.Time" {
-				time = "\n\"time\"\n"
-			}
-		}
-		buffer.WriteString("package ")
-		buffer.WriteString(packageName)
-		buffer.WriteString("\n\n")
-		buffer.WriteString("import (\n")
-		buffer.WriteString("\"database/sql\"\n//DB Driver\n_ \"github.com/lib/pq\"\n\"encoding/json\"\n\"log\"")
-		buffer.WriteString(time)
-		if structFromFile.nullsPkg {
-			buffer.WriteString("\"github.com/markbates/going/nulls\"")
-		}
-		buffer.WriteString("\n)\n")
-	}
-
 	//Write global variable if generated code will be using prepared stmts
 	var dataLayerVar string = LowerCaseFirstChar(structFromFile.structName) + "SQL"
+	//DB pointer for queries that are built at run time
+	dbVar := structFromFile.structName + "DB"
+	if structFromFile.prepared {
+		dbVar = dataLayerVar + ".DB"
+	}
 	if structFromFile.prepared {
 		buffer.WriteString("\n//Global Data Layer\n")
 		buffer.WriteString(fmt.Sprintf("var %s %sDataLayer\n", dataLayerVar, structFromFile.structName))
@@ -100,15 +361,18 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 	//Get name of primary column and deleted column
 	for _, col := range structFromFile.cols {
 		if col.primary {
-			primColName = col.colName
-			primVarName = col.varName
-			primVarType = col.goType
+			if primVarName == "" {
+				primVarName = col.varName
+				primVarType = col.goType
+			}
 		} else if col.deleted {
 			//ignore [nulls] if a column is marked as [deleted]
 			if col.nulls {
 				col.dbType = "boolean"
+				col.structLine = strings.Replace(col.structLine, col.goType, "bool", 1)
 				col.goType = "bool"
-				col.structLine = strings.Replace(col.structLine, "nulls.Bool", "bool", 1)
+				col.baseType = ""
+				col.nullStyle = ""
 				col.nulls = false
 			}
 			delColName = col.colName
@@ -117,13 +381,93 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 		} else if col.deletedOn {
 			delOnColName = col.colName
 			delOnColType = "time.Time"
+			delOnZero = "time.Time{}"
 			if col.nulls {
-				delOnColType = "nulls.Time"
+				delOnColType = col.goType
+				delOnZero = col.NullZero()
 			}
 			delOnVarName = col.varName
 		}
 	}
 
+	//Arrays are passed through pq.Array, jsonb, interval and inet variables through the struct's converter
+	valueType := structObject + "Value"
+	scanVar := func(col *column, obj string) string {
+		switch col.ValueKind() {
+		case "array":
+			return fmt.Sprintf("pq.Array(&%s.%s)", obj, col.varName)
+		case "jsonb", "interval", "inet":
+			return fmt.Sprintf("%s{&%s.%s}", valueType, obj, col.varName)
+		}
+		return "&" + obj + "." + col.varName
+	}
+	argVar := func(col *column, expr string) string {
+		switch col.ValueKind() {
+		case "":
+			return expr
+		case "array":
+			return "pq.Array(" + expr + ")"
+		}
+		return fmt.Sprintf("%s{&%s}", valueType, expr)
+	}
+
+	//A composite primary key is matched, passed and ordered by every one of its columns
+	primCols := structFromFile.PrimaryCols()
+	var primColNames []string
+	var primParams []string
+	var primArgs []string
+	var primObjArgs []string
+	var primDesc []string
+	var primOrderBy string
+	for _, col := range primCols {
+		primColNames = append(primColNames, col.colName)
+		primParams = append(primParams, LowerCaseFirstChar(col.varName)+" "+col.goType)
+		primArgs = append(primArgs, LowerCaseFirstChar(col.varName))
+		primObjArgs = append(primObjArgs, structObject+"."+col.varName)
+		primDesc = append(primDesc, col.colName+" DESC")
+		primOrderBy += ".OrderBy" + UpperCaseFirstChar(col.varName) + "()"
+	}
+	primColName = strings.Join(primColNames, ", ")
+	keyN := len(primCols)
+	primWhere := func(n int) string {
+		var where []string
+		for i, col := range primCols {
+			where = append(where, fmt.Sprintf("%s = $%d", col.colName, n+i))
+		}
+		return strings.Join(where, " and ")
+	}
+
+	//UPDATEs check and increment the [version] column and set the [updatedOn] column if there are ones,
+	//their new values are read back with RETURNING
+	var versionColName string
+	var versionVarName string
+	var writeSet string
+	var writeReturning []string
+	var writeScan []string
+	for _, col := range structFromFile.cols {
+		if col.version {
+			versionColName = col.colName
+			versionVarName = col.varName
+			writeSet += fmt.Sprintf(", %s = %s + 1", col.colName, col.colName)
+		} else if col.updatedOn {
+			writeSet += fmt.Sprintf(", %s = now()", col.colName)
+		} else {
+			continue
+		}
+		writeReturning = append(writeReturning, col.colName)
+		writeScan = append(writeScan, "&"+structObject+"."+col.varName)
+	}
+	writeWhere := func(n int) string {
+		where := ""
+		if versionColName != "" {
+			where = fmt.Sprintf(" and %s = $%d", versionColName, n)
+		}
+		if len(writeReturning) > 0 {
+			where += " RETURNING " + strings.Join(writeReturning, ", ")
+		}
+		return where
+	}
+
 	//Create query statements
 	var indexMethods [][]string
 	var patchMethods [][]string
@@ -134,81 +478,341 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 	var objectVars []string
 	var updateVars []string
 	var insertVars []string
-	var sqlVarFinal string
 	i := 0
 	for _, col := range structFromFile.cols {
 		//build slices for insert and update statements
-		if !col.primary {
+		if col.primary && !col.Sequenced() {
+			//[uuid] and natural keys are inserted with the row
+			insertSet = append(insertSet, col.colName)
+			insertVals = append(insertVals, col.KeyInsertValue("$"+strconv.Itoa(len(insertVals)+1)))
+			insertVars = append(insertVars, structObject+"."+col.varName)
+		}
+		if !col.primary && !col.DBManaged() {
 			i += 1
 			updateSet = append(updateSet, col.colName+" = $"+strconv.Itoa(i))
 			insertSet = append(insertSet, col.colName)
-			insertVals = append(insertVals, "$"+strconv.Itoa(i))
-			insertVars = append(insertVars, structObject+"."+col.varName)
-			updateVars = append(updateVars, structObject+"."+col.varName)
+			insertVals = append(insertVals, col.InsertValue("$"+strconv.Itoa(len(insertVals)+1), structFromFile.insertZeros))
+			insertVars = append(insertVars, argVar(col, structObject+"."+col.varName))
+			updateVars = append(updateVars, argVar(col, structObject+"."+col.varName))
 		}
-		selectVals = append(selectVals, col.colName)
-		objectVars = append(objectVars, "&"+structObject+"."+col.varName)
+		selectVals = append(selectVals, col.SelectExpr())
+		objectVars = append(objectVars, scanVar(col, structObject))
 	}
-	sqlVarFinal = "$" + strconv.Itoa(len(structFromFile.cols))
-	updateVars = append(updateVars, structObject+"."+primVarName)
+	updateVars = append(updateVars, primObjArgs...)
+
+	//Snippets shared by the methods that filter deleted rows, return slices and track changes
+	snapshot := ""
+	if structFromFile.trackChanges {
+		snapshot = structObject + ".takeSnapshot()\n"
+	}
+	delSwitch := "deleted1 := false\ndeleted2 := false\nswitch delFilter {\ncase DELETED" + strings.ToUpper(structFromFile.structName) + ":\ndeleted1 = true\ndeleted2 = true\ncase ALL" + strings.ToUpper(structFromFile.structName) + ":\ndeleted2 = true\n}\n"
+	//updateCall runs an UPDATE and reads back the RETURNING columns, with a [version] column
+	//no returned row means someone else changed (or deleted) the row first
+	updateErr := "if err != nil {\nlog.Println(err.Error())\nreturn err\n}\n"
+	if versionVarName != "" {
+		updateErr = fmt.Sprintf("if err == sql.ErrNoRows {\nreturn Err%sVersionConflict\n}\n%s", structFromFile.structName, updateErr)
+	}
+	updateCall := func(target string, args string) string {
+		if len(writeReturning) == 0 {
+			return fmt.Sprintf("_, err := %s.Exec(%s)\n%s", target, args, updateErr)
+		}
+		if versionVarName != "" {
+			args += ", " + structObject + "." + versionVarName
+		}
+		return fmt.Sprintf("err := %s.QueryRow(%s).Scan(%s)\n%s", target, args, strings.Join(writeScan, ", "), updateErr)
+	}
+	rowsToSlice := fmt.Sprintf("%ss := []*%s{}\nfor rows.Next() {\n%s := new(%s)\nif err = rows.Scan(%s); err != nil {\nlog.Println(err.Error())\nrows.Close()\nreturn %ss, err\n}\n%s%ss = append(%ss, %s)\n}\n\nrows.Close()\nreturn %ss, nil\n}\n\n", structObject, structFromFile.structName, structObject, structFromFile.structName, strings.Join(objectVars, ", "), structObject, snapshot, structObject, structObject, structObject, structObject)
 
 	for _, col := range structFromFile.cols {
 		if col.index {
-			indexMethods = append(indexMethods, []string{fmt.Sprintf("Get%ssBy%s", structFromFile.structName, UpperCaseFirstChar(col.varName)), fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1 ORDER BY %s", strings.Join(selectVals, ", "), tablePathName, col.colName, primColName), LowerCaseFirstChar(col.varName), col.goType, fmt.Sprintf("GetBy%s", UpperCaseFirstChar(col.varName))})
+			indexMethods = append(indexMethods, []string{fmt.Sprintf("Get%ssBy%s", structFromFile.structName, UpperCaseFirstChar(col.varName)), fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1 ORDER BY %s", strings.Join(selectVals, ", "), tablePathName, col.colName, primColName), LowerCaseFirstChar(col.varName), col.goType, fmt.Sprintf("GetBy%s", UpperCaseFirstChar(col.varName)), fmt.Sprintf("%s %s", LowerCaseFirstChar(col.varName), col.goType), argVar(col, LowerCaseFirstChar(col.varName))})
 			if delColName != "" {
 				indexMethods[len(indexMethods)-1][1] = fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1 and (%s = $2 or %s = $3) ORDER BY %s", strings.Join(selectVals, ", "), tablePathName, col.colName, delColName, delColName, primColName)
 			}
 		}
 		if col.patch {
-			patchMethods = append(patchMethods, []string{"Patch" + UpperCaseFirstChar(col.varName), fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", tablePathName, col.colName, primColName), LowerCaseFirstChar(col.varName), col.goType, fmt.Sprintf("Patch%s", UpperCaseFirstChar(col.varName)), col.varName})
+			patchMethods = append(patchMethods, []string{"Patch" + UpperCaseFirstChar(col.varName), fmt.Sprintf("UPDATE %s SET %s = $1%s WHERE %s%s", tablePathName, col.colName, writeSet, primWhere(2), writeWhere(2+keyN)), LowerCaseFirstChar(col.varName), col.goType, fmt.Sprintf("Patch%s", UpperCaseFirstChar(col.varName)), col.varName, argVar(col, LowerCaseFirstChar(col.varName))})
+		}
+	}
+
+	//Build List queries, one per permitted ORDER BY column and direction (primary key, [index] and [unique] columns)
+	var listMethods [][]string
+	var preparedStmts [][]string
+	listWhere := ""
+	listLimit := "LIMIT $1 OFFSET $2"
+	afterWhere := ""
+	afterLimit := fmt.Sprintf("LIMIT $%d", keyN+1)
+	if delColName != "" {
+		listWhere = fmt.Sprintf(" WHERE (%s = $1 or %s = $2)", delColName, delColName)
+		listLimit = "LIMIT $3 OFFSET $4"
+		afterWhere = fmt.Sprintf(" and (%s = $%d or %s = $%d)", delColName, keyN+1, delColName, keyN+2)
+		afterLimit = fmt.Sprintf("LIMIT $%d", keyN+3)
+	}
+	for _, col := range structFromFile.cols {
+		if !col.primary && !col.index && !col.unique {
+			continue
+		}
+		orderBy := col.colName
+		orderByDesc := col.colName + " DESC"
+		if !col.primary || col.keyPart {
+			//break ties on the (rest of the) primary key so pages are stable
+			for _, keyCol := range primCols {
+				if keyCol != col {
+					orderBy += ", " + keyCol.colName
+					orderByDesc += ", " + keyCol.colName + " DESC"
+				}
+			}
+		}
+		listMethods = append(listMethods, []string{fmt.Sprintf("ORDER%sBY%s", strings.ToUpper(structFromFile.structName), strings.ToUpper(col.varName)), fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s %s", strings.Join(selectVals, ", "), tablePathName, listWhere, orderBy, listLimit), fmt.Sprintf("ListBy%s", UpperCaseFirstChar(col.varName))})
+		listMethods = append(listMethods, []string{fmt.Sprintf("ORDER%sBY%sDESC", strings.ToUpper(structFromFile.structName), strings.ToUpper(col.varName)), fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s %s", strings.Join(selectVals, ", "), tablePathName, listWhere, orderByDesc, listLimit), fmt.Sprintf("ListBy%sDesc", UpperCaseFirstChar(col.varName))})
+	}
+	for _, method := range listMethods {
+		preparedStmts = append(preparedStmts, []string{method[2], method[1]})
+	}
+	//Build patch group queries, one UPDATE for all columns in the group
+	var patchGroupStmts []string
+	for _, group := range structFromFile.PatchGroups() {
+		var groupSet []string
+		for i, col := range structFromFile.PatchGroupCols(group) {
+			groupSet = append(groupSet, fmt.Sprintf("%s = $%d", col.colName, i+1))
+		}
+		patchGroupStmts = append(patchGroupStmts, fmt.Sprintf("UPDATE %s SET %s%s WHERE %s%s", tablePathName, strings.Join(groupSet, ", "), writeSet, primWhere(len(groupSet)+1), writeWhere(len(groupSet)+1+keyN)))
+		preparedStmts = append(preparedStmts, []string{"Patch" + UpperCaseFirstChar(group), patchGroupStmts[len(patchGroupStmts)-1]})
+	}
+
+	//Build Count and Exists queries
+	var countMethods [][]string
+	//Composite [index:group] lookups take every column of the index
+	for _, key := range structFromFile.IndexKeys() {
+		if !key.group {
+			continue
+		}
+		var keyWhere []string
+		var keyParams []string
+		var keyArgs []string
+		var keyVars []string
+		for i, col := range key.cols {
+			keyWhere = append(keyWhere, fmt.Sprintf("%s = $%d", col.colName, i+1))
+			keyParams = append(keyParams, fmt.Sprintf("%s %s", LowerCaseFirstChar(col.varName), col.goType))
+			keyArgs = append(keyArgs, LowerCaseFirstChar(col.varName))
+			keyVars = append(keyVars, argVar(col, LowerCaseFirstChar(col.varName)))
 		}
+		groupStmt := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(selectVals, ", "), tablePathName, strings.Join(keyWhere, " and "))
+		if delColName != "" {
+			groupStmt = fmt.Sprintf("%s and (%s = $%d or %s = $%d)", groupStmt, delColName, len(key.cols)+1, delColName, len(key.cols)+2)
+		}
+		indexMethods = append(indexMethods, []string{fmt.Sprintf("Get%ss%s", structFromFile.structName, KeyMethodSuffix(key.cols)), fmt.Sprintf("%s ORDER BY %s", groupStmt, primColName), strings.Join(keyArgs, ", "), "", "Get" + KeyMethodSuffix(key.cols), strings.Join(keyParams, ", "), strings.Join(keyVars, ", ")})
+	}
+
+	//Build single row lookups for [unique] columns and [unique:group] keys
+	var uniqueMethods [][]string
+	for _, key := range structFromFile.UniqueKeys() {
+		var keyWhere []string
+		var keyParams []string
+		var keyArgs []string
+		var keyVars []string
+		for i, col := range key.cols {
+			keyWhere = append(keyWhere, fmt.Sprintf("%s = $%d", col.colName, i+1))
+			keyParams = append(keyParams, fmt.Sprintf("%s %s", LowerCaseFirstChar(col.varName), col.goType))
+			keyArgs = append(keyArgs, LowerCaseFirstChar(col.varName))
+			keyVars = append(keyVars, argVar(col, LowerCaseFirstChar(col.varName)))
+		}
+		uniqueStmt := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(selectVals, ", "), tablePathName, strings.Join(keyWhere, " and "))
+		if delColName != "" {
+			uniqueStmt = fmt.Sprintf("%s and (%s = $%d or %s = $%d)", uniqueStmt, delColName, len(key.cols)+1, delColName, len(key.cols)+2)
+		}
+		uniqueMethods = append(uniqueMethods, []string{"Get" + structFromFile.structName + key.MethodSuffix(), uniqueStmt, strings.Join(keyParams, ", "), strings.Join(keyArgs, ", "), "GetOne" + key.MethodSuffix(), strings.Join(keyVars, ", ")})
+		preparedStmts = append(preparedStmts, []string{"GetOne" + key.MethodSuffix(), uniqueStmt})
 	}
 
-	selectStmt := fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1", strings.Join(selectVals, ", "), tablePathName, primColName)
+	countStmt := fmt.Sprintf("SELECT COUNT(*) FROM %s", tablePathName)
+	existsStmt := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE %s)", tablePathName, primWhere(1))
 	if delColName != "" {
-		selectStmt = fmt.Sprintf("%s and (%s = $2 or %s = $3)", selectStmt, delColName, delColName)
+		countStmt = fmt.Sprintf("%s WHERE (%s = $1 or %s = $2)", countStmt, delColName, delColName)
+		existsStmt = fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE %s and (%s = $%d or %s = $%d))", tablePathName, primWhere(1), delColName, keyN+1, delColName, keyN+2)
+	}
+	preparedStmts = append(preparedStmts, []string{"Count", countStmt}, []string{"Exists", existsStmt})
+	for _, col := range structFromFile.cols {
+		if col.index {
+			countMethods = append(countMethods, []string{fmt.Sprintf("Count%ssBy%s", structFromFile.structName, UpperCaseFirstChar(col.varName)), fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = $1", tablePathName, col.colName), LowerCaseFirstChar(col.varName), col.goType, fmt.Sprintf("CountBy%s", UpperCaseFirstChar(col.varName)), argVar(col, LowerCaseFirstChar(col.varName))})
+			if delColName != "" {
+				countMethods[len(countMethods)-1][1] = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = $1 and (%s = $2 or %s = $3)", tablePathName, col.colName, delColName, delColName)
+			}
+			preparedStmts = append(preparedStmts, []string{countMethods[len(countMethods)-1][4], countMethods[len(countMethods)-1][1]})
+		}
 	}
-	updateStmt := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s", tablePathName, strings.Join(updateSet, ", "), primColName, sqlVarFinal)
-	insertStmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING %s", tablePathName, strings.Join(insertSet, ", "), strings.Join(insertVals, ", "), primColName)
-	markDelStmt := fmt.Sprintf("UPDATE %s SET %s = $1, %s = $2 WHERE %s = $3", tablePathName, delColName, delOnColName, primColName)
-	delStmt := fmt.Sprintf("DELETE from %s WHERE %s = $1", tablePathName, primColName)
+
+	//Build queries for rows marked as deleted
+	var listDeletedStmt string
+	var countDeletedStmt string
+	var purgeDeletedStmt string
+	if delColName != "" {
+		listDeletedStmt = fmt.Sprintf("SELECT %s FROM %s WHERE %s = true ORDER BY %s DESC NULLS LAST, %s LIMIT $1 OFFSET $2", strings.Join(selectVals, ", "), tablePathName, delColName, delOnColName, strings.Join(primDesc, ", "))
+		countDeletedStmt = fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = true", tablePathName, delColName)
+		purgeDeletedStmt = fmt.Sprintf("DELETE FROM %s WHERE %s = true and %s < $1", tablePathName, delColName, delOnColName)
+		preparedStmts = append(preparedStmts, []string{"ListDeleted", listDeletedStmt}, []string{"CountDeleted", countDeletedStmt}, []string{"PurgeDeleted", purgeDeletedStmt})
+	}
+
+	//Build Upsert query, the primary key is only inserted when it is part of the ON CONFLICT target
+	var upsertStmt string
+	var upsertVars []string
+	if structFromFile.hasUpsert {
+		var conflictCols []string
+		var upsertCols []string
+		var upsertVals []string
+		var upsertSet []string
+		primInConflict := false
+		for _, col := range structFromFile.UpsertConflictCols() {
+			conflictCols = append(conflictCols, col.colName)
+			if col.primary {
+				primInConflict = true
+			}
+		}
+		for _, col := range structFromFile.cols {
+			if col.primary && !primInConflict && col.Sequenced() {
+				continue
+			}
+			if col.version {
+				//new rows start at the default version, updated rows are incremented, the
+				//existing row is named by the t alias as the table name may be a reserved word
+				upsertSet = append(upsertSet, fmt.Sprintf("%s = t.%s + 1", col.colName, col.colName))
+				continue
+			}
+			if col.updatedOn {
+				upsertSet = append(upsertSet, fmt.Sprintf("%s = now()", col.colName))
+				continue
+			}
+			if col.createdOn {
+				continue
+			}
+			upsertCols = append(upsertCols, col.colName)
+			upsertVars = append(upsertVars, argVar(col, structObject+"."+col.varName))
+			if col.primary && !col.Sequenced() {
+				upsertVals = append(upsertVals, col.KeyInsertValue(fmt.Sprintf("$%d", len(upsertVars))))
+			} else if col.primary {
+				//a zero primary key takes the next value of the sequence
+				upsertVals = append(upsertVals, fmt.Sprintf("COALESCE(NULLIF($%d::bigint, 0), %s)", len(upsertVars), structFromFile.NextvalExpr(col)))
+			} else {
+				upsertVals = append(upsertVals, col.InsertValue(fmt.Sprintf("$%d", len(upsertVars)), structFromFile.insertZeros))
+				upsertSet = append(upsertSet, fmt.Sprintf("%s = EXCLUDED.%s", col.colName, col.colName))
+			}
+		}
+		upsertStmt = fmt.Sprintf("INSERT INTO %s AS t (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s RETURNING %s", tablePathName, strings.Join(upsertCols, ", "), strings.Join(upsertVals, ", "), strings.Join(conflictCols, ", "), strings.Join(upsertSet, ", "), strings.Join(selectVals, ", "))
+		preparedStmts = append(preparedStmts, []string{"Upsert", upsertStmt})
+	}
+
+	afterKey := fmt.Sprintf("%s > $1", primColName)
+	if keyN > 1 {
+		//a row comparison keeps the pages in primary key order
+		var afterParams []string
+		for i := range primCols {
+			afterParams = append(afterParams, fmt.Sprintf("$%d", i+1))
+		}
+		afterKey = fmt.Sprintf("(%s) > (%s)", primColName, strings.Join(afterParams, ", "))
+	}
+	listAfterStmt := fmt.Sprintf("SELECT %s FROM %s WHERE %s%s ORDER BY %s %s", strings.Join(selectVals, ", "), tablePathName, afterKey, afterWhere, primColName, afterLimit)
+	preparedStmts = append(preparedStmts, []string{"ListAfter", listAfterStmt})
+
+	//Build the join table statements for each [many to many] line, keyed by the other struct
+	joinStmts := make(map[*manyToMany][]string)
+	for _, join := range structFromFile.joins {
+		own, other := 0, 1
+		if join.structs[1] == structFromFile {
+			own, other = 1, 0
+		}
+		joinPath := fmt.Sprintf("%s.%s.%s", AddQuotesIfAnyUpperCase(join.structs[0].database), AddQuotesIfAnyUpperCase(join.structs[0].schema), join.tableName)
+		otherName := join.structs[other].structName
+		addStmt := fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES ($1, $2) ON CONFLICT DO NOTHING", joinPath, join.colNames[own], join.colNames[other])
+		removeStmt := fmt.Sprintf("DELETE FROM %s WHERE %s = $1 and %s = $2", joinPath, join.colNames[own], join.colNames[other])
+		joinStmts[join] = []string{addStmt, removeStmt, joinPath, join.colNames[own], join.colNames[other]}
+		preparedStmts = append(preparedStmts, []string{"Add" + otherName, addStmt}, []string{"Remove" + otherName, removeStmt})
+	}
+
+	selectStmt := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(selectVals, ", "), tablePathName, primWhere(1))
+	if delColName != "" {
+		selectStmt = fmt.Sprintf("%s and (%s = $%d or %s = $%d)", selectStmt, delColName, keyN+1, delColName, keyN+2)
+	}
+	//Insert leaves the DB managed columns to their defaults and reads them back, along with
+	//[default] columns that may have taken their default
+	insertReturning := primColName
+	insertScan := "&" + strings.Join(primObjArgs, ", &")
+	batchScan := "&batch[i]." + primCols[0].varName
+	for _, col := range primCols[1:] {
+		batchScan += ", &batch[i]." + col.varName
+	}
+	for _, col := range structFromFile.cols {
+		if col.DBManaged() || (col.defaultExpr != "" && !structFromFile.insertZeros) {
+			insertReturning += ", " + col.colName
+			insertScan += ", &" + structObject + "." + col.varName
+			batchScan += ", &batch[i]." + col.varName
+		}
+	}
+	updateStmt := fmt.Sprintf("UPDATE %s SET %s%s WHERE %s%s", tablePathName, strings.Join(updateSet, ", "), writeSet, primWhere(len(updateVars)-keyN+1), writeWhere(len(updateVars)+1))
+	insertStmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING %s", tablePathName, strings.Join(insertSet, ", "), strings.Join(insertVals, ", "), insertReturning)
+	markDelStmt := fmt.Sprintf("UPDATE %s SET %s = $1, %s = $2%s WHERE %s%s", tablePathName, delColName, delOnColName, writeSet, primWhere(3), writeWhere(3+keyN))
+	delStmt := fmt.Sprintf("DELETE from %s WHERE %s", tablePathName, primWhere(1))
 	constStmt := fmt.Sprintf("\n//Constants used to alter Get queries (for rows marked as deleted)\nconst (\nEXISTS%s = iota\nDELETED%s = iota\nALL%s = iota\n)\n", strings.ToUpper(structFromFile.structName), strings.ToUpper(structFromFile.structName), strings.ToUpper(structFromFile.structName))
 	//End Create query statements
 
+	//Write the Go types of the [enum] lines this struct uses first
+	for _, enum := range structFromFile.enums {
+		buffer.WriteString(BuildEnumCode(enum))
+	}
+
 	//Write constants used to alter Get queries
 	if delColName != "" {
 		buffer.WriteString(constStmt)
 	}
 
+	//Write the error returned when the [version] doesn't match
+	if versionColName != "" {
+		buffer.WriteString(fmt.Sprintf("\n//Err%sVersionConflict is returned when the row was changed or deleted since the %s was loaded\nvar Err%sVersionConflict = errors.New(\"%s: the row was changed by someone else, reload it and try again\")\n", structFromFile.structName, structFromFile.structName, structFromFile.structName, structObject))
+	}
+
+	//Write the [nested struct] types this struct uses first
+	for _, nested := range structFromFile.nestedTypes {
+		buffer.WriteString(fmt.Sprintf("\ntype %s struct {\n%s}\n", nested.structName, BuildStructFields(nested.cols)))
+	}
+
 	//Write struct
 	buffer.WriteString("\ntype ")
 	buffer.WriteString(structFromFile.structName)
 	buffer.WriteString(" struct {\n")
-	for _, col := range structFromFile.cols {
-		buffer.WriteString(col.structLine)
-		buffer.WriteString("\n")
+	buffer.WriteString(BuildStructFields(structFromFile.cols))
+	if structFromFile.trackChanges {
+		buffer.WriteString(fmt.Sprintf("//values when loaded from or last saved to the DB\nsnapshot *%s\n", structFromFile.structName))
 	}
 	buffer.WriteString("}\n\n")
+	buffer.WriteString(BuildSQLNullJSON(structFromFile, structObject))
+
+	//Write the converter for jsonb, interval and inet variables
+	kinds := make(map[string]bool)
+	for _, col := range structFromFile.cols {
+		kinds[col.ValueKind()] = true
+	}
+	if kinds["jsonb"] || kinds["interval"] || kinds["inet"] {
+		buffer.WriteString(BuildValueConverter(structFromFile.structName, valueType, kinds))
+	}
 
 	//Write New()
 	delFilter := ""
 	if delColName != "" {
 		delFilter = ", delFilter int"
 	}
-	buffer.WriteString(fmt.Sprintf("//Initialize and fill a %s object from the DB\nfunc New%s(%s %s%s) (*%s, error) {\n", structFromFile.structName, structFromFile.structName, LowerCaseFirstChar(primVarName), primVarType, delFilter, structFromFile.structName))
+	buffer.WriteString(fmt.Sprintf("//Initialize and fill a %s object from the DB\nfunc New%s(%s%s) (*%s, error) {\n", structFromFile.structName, structFromFile.structName, strings.Join(primParams, ", "), delFilter, structFromFile.structName))
 	buffer.WriteString(fmt.Sprintf("%s := new(%s)\n", structObject, structFromFile.structName))
 	delFilter = ""
 	if delColName != "" {
 		delFilter = ", deleted1, deleted2"
-		buffer.WriteString("deleted1 := false\ndeleted2 := false\nswitch delFilter {\ncase DELETED" + strings.ToUpper(structFromFile.structName) + ":\ndeleted1 = true\ndeleted2 = true\ncase ALL" + strings.ToUpper(structFromFile.structName) + ":\ndeleted2 = true\n}\n")
+		buffer.WriteString(delSwitch)
 	}
 	if structFromFile.prepared {
-		buffer.WriteString(fmt.Sprintf("row := %s.GetByID.QueryRow(%s%s)\n", dataLayerVar, LowerCaseFirstChar(primVarName), delFilter))
+		buffer.WriteString(fmt.Sprintf("row := %s.GetByID.QueryRow(%s%s)\n", dataLayerVar, strings.Join(primArgs, ", "), delFilter))
 	} else {
-		buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s%s)\n", structFromFile.structName, selectStmt, LowerCaseFirstChar(primVarName), delFilter))
+		buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s%s)\n", structFromFile.structName, selectStmt, strings.Join(primArgs, ", "), delFilter))
 	}
 	buffer.WriteString(fmt.Sprintf("err := row.Scan(%s)\n", strings.Join(objectVars, ", ")))
-	buffer.WriteString(fmt.Sprintf("if err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\nreturn %s, nil\n}\n\n", structObject))
+	buffer.WriteString(fmt.Sprintf("if err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\n%sreturn %s, nil\n}\n\n", snapshot, structObject))
 
 	//Write UserFromJSON()
 	buffer.WriteString(fmt.Sprintf("//Transform JSON into a %s object\nfunc %sFromJSON(%sJSON []byte) (*%s, error) {\n", structFromFile.structName, structFromFile.structName, structObject, structFromFile.structName))
@@ -228,59 +832,257 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 	if delColName != "" {
 		delFilter = ", delFilter int"
 	}
-	buffer.WriteString(fmt.Sprintf("//Fill %s object with data from DB\nfunc (%s *%s) GetByID(%s %s%s) error {\n", structFromFile.structName, structObject, structFromFile.structName, LowerCaseFirstChar(primVarName), primVarType, delFilter))
+	buffer.WriteString(fmt.Sprintf("//Fill %s object with data from DB\nfunc (%s *%s) GetByID(%s%s) error {\n", structFromFile.structName, structObject, structFromFile.structName, strings.Join(primParams, ", "), delFilter))
 	delFilter = ""
 	if delColName != "" {
 		delFilter = ", deleted1, deleted2"
-		buffer.WriteString("deleted1 := false\ndeleted2 := false\nswitch delFilter {\ncase DELETED" + strings.ToUpper(structFromFile.structName) + ":\ndeleted1 = true\ndeleted2 = true\ncase ALL" + strings.ToUpper(structFromFile.structName) + ":\ndeleted2 = true\n}\n")
+		buffer.WriteString(delSwitch)
 	}
 	if structFromFile.prepared {
-		buffer.WriteString(fmt.Sprintf("row := %s.GetByID.QueryRow(%s%s)\n", dataLayerVar, LowerCaseFirstChar(primVarName), delFilter))
+		buffer.WriteString(fmt.Sprintf("row := %s.GetByID.QueryRow(%s%s)\n", dataLayerVar, strings.Join(primArgs, ", "), delFilter))
 	} else {
-		buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s%s)\n", structFromFile.structName, selectStmt, LowerCaseFirstChar(primVarName), delFilter))
+		buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s%s)\n", structFromFile.structName, selectStmt, strings.Join(primArgs, ", "), delFilter))
 	}
 	buffer.WriteString(fmt.Sprintf("err := row.Scan(%s)\n", strings.Join(objectVars, ", ")))
-	buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn err\n}\nreturn nil\n}\n\n")
+	buffer.WriteString(fmt.Sprintf("if err != nil {\nlog.Println(err.Error())\nreturn err\n}\n%sreturn nil\n}\n\n", snapshot))
 
 	//Write Insert()
 	buffer.WriteString(fmt.Sprintf("//Insert %s object to DB\nfunc (%s *%s) Insert() error {\n", structFromFile.structName, structObject, structFromFile.structName))
 	if structFromFile.prepared {
-		buffer.WriteString(fmt.Sprintf("var id int\n row := %s.Insert.QueryRow(%s)\n", dataLayerVar, strings.Join(insertVars, ", ")))
+		buffer.WriteString(fmt.Sprintf("row := %s.Insert.QueryRow(%s)\n", dataLayerVar, strings.Join(insertVars, ", ")))
 	} else {
-		buffer.WriteString(fmt.Sprintf("var id int\n row := %sDB.QueryRow(\"%s\", %s)\n", structFromFile.structName, insertStmt, strings.Join(insertVars, ", ")))
+		buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s)\n", structFromFile.structName, insertStmt, strings.Join(insertVars, ", ")))
 	}
-	buffer.WriteString(fmt.Sprintf("err := row.Scan(&id)\nif err != nil {\nlog.Println(err.Error())\nreturn err\n}\n%s.%s = id\nreturn nil\n}\n\n", structObject, primVarName))
+	buffer.WriteString(fmt.Sprintf("err := row.Scan(%s)\nif err != nil {\nlog.Println(err.Error())\nreturn err\n}\n%sreturn nil\n}\n\n", insertScan, snapshot))
+
+	//Write InsertManyObjects()
+	//Postgres doesn't promise RETURNING rows in VALUES order, so every row gets its key first and
+	//the returned columns are matched to the structs by key
+	primCol := structFromFile.PrimaryCol()
+	manySet := insertSet
+	manyVars := insertVars
+	if primCol.Sequenced() {
+		manySet = append(append([]string{}, insertSet...), primColName)
+		manyVars = append(append([]string{}, insertVars...), structObject+"."+primVarName)
+	}
+	batchSize := 1000
+	if len(manySet) > 0 && 65535/len(manySet) < batchSize {
+		//Postgres takes at most 65535 parameters in a statement
+		batchSize = 65535 / len(manySet)
+	}
+	keyExpr := func(obj string) string {
+		if len(primCols) > 1 {
+			var parts []string
+			for _, col := range primCols {
+				parts = append(parts, obj+"."+col.varName)
+			}
+			return fmt.Sprintf("[%d]interface{}{%s}", len(primCols), strings.Join(parts, ", "))
+		}
+		if primCol.uuid && strings.ToLower(primCol.goType) == "string" {
+			//the DB returns a uuid in lower case
+			return fmt.Sprintf("strings.ToLower(%s.%s)", obj, primVarName)
+		}
+		return obj + "." + primVarName
+	}
+	keyType := primCol.goType
+	if len(primCols) > 1 {
+		keyType = fmt.Sprintf("[%d]interface{}", len(primCols))
+	}
+	var returnedCols []*column
+	for _, col := range structFromFile.cols {
+		if col.DBManaged() || (col.defaultExpr != "" && !structFromFile.insertZeros) {
+			returnedCols = append(returnedCols, col)
+		}
+	}
+	manyNote := ""
+	if primCol.Sequenced() {
+		manyNote = fmt.Sprintf("//%ss are reserved from the sequence first, if an INSERT fails no rows are inserted and the %ss are zero again\n", primVarName, primVarName)
+	} else if primCol.GeneratedKey() {
+		manyNote = fmt.Sprintf("//Zero %ss are generated first, if an INSERT fails no rows are inserted and the %ss are zero again\n", primVarName, primVarName)
+	}
+	buffer.WriteString(fmt.Sprintf("//Insert multiple %s objects to DB in one transaction using multi-row INSERTs of up to %d rows, filling in their %ss\n%sfunc InsertMany%ss(%ss []*%s) error {\n", structFromFile.structName, batchSize, primVarName, manyNote, structFromFile.structName, structObject, structFromFile.structName))
+	buffer.WriteString(fmt.Sprintf("txn, err := %s.Begin()\nif err != nil {\nlog.Println(err.Error())\nreturn err\n}\n", dbVar))
+	resetKeys := ""
+	idQuery := ""
+	if primCol.Sequenced() || primCol.GeneratedKey() {
+		idQuery = fmt.Sprintf("SELECT %s FROM generate_series(1, $1)", structFromFile.NextvalExpr(primCol))
+		if primCol.GeneratedKey() {
+			//only rows without a key get a new one
+			idQuery = "SELECT gen_random_uuid() FROM generate_series(1, $1)"
+			buffer.WriteString(fmt.Sprintf("var unkeyed []*%s\nfor _, %s := range %ss {\nif %s.%s == %s {\nunkeyed = append(unkeyed, %s)\n}\n}\n", structFromFile.structName, structObject, structObject, structObject, primVarName, primCol.ZeroValue(), structObject))
+		} else {
+			buffer.WriteString(fmt.Sprintf("unkeyed := %ss\n", structObject))
+		}
+		resetKeys = fmt.Sprintf("for _, %s := range unkeyed {\n%s.%s = %s\n}\n", structObject, structObject, primVarName, primCol.ZeroValue())
+	}
+	buffer.WriteString(fmt.Sprintf("fail := func(err error) error {\nlog.Println(err.Error())\ntxn.Rollback()\n%sreturn err\n}\n", resetKeys))
+	if idQuery != "" {
+		buffer.WriteString(fmt.Sprintf("ids, err := txn.Query(\"%s\", len(unkeyed))\nif err != nil {\nreturn fail(err)\n}\n", idQuery))
+		buffer.WriteString(fmt.Sprintf("for i := 0; ids.Next() && i < len(unkeyed); i++ {\nif err = ids.Scan(&unkeyed[i].%s); err != nil {\nids.Close()\nreturn fail(err)\n}\n}\nids.Close()\n", primVarName))
+	}
+	if len(returnedCols) > 0 {
+		buffer.WriteString(fmt.Sprintf("byKey := make(map[%s]*%s, len(%ss))\nfor _, %s := range %ss {\nbyKey[%s] = %s\n}\nvar returned []*%s\n", keyType, structFromFile.structName, structObject, structObject, structObject, keyExpr(structObject), structObject, structFromFile.structName))
+	}
+	buffer.WriteString(fmt.Sprintf("for start := 0; start < len(%ss); start += %d {\nbatch := %ss[start:]\nif len(batch) > %d {\nbatch = batch[:%d]\n}\n", structObject, batchSize, structObject, batchSize, batchSize))
+	buffer.WriteString(fmt.Sprintf("values := make([]string, 0, len(batch))\nargs := make([]interface{}, 0, len(batch)*%d)\nfor i, %s := range batch {\nplaceholders := make([]string, %d)\nfor j := range placeholders {\nplaceholders[j] = \"$\" + strconv.Itoa(i*%d+j+1)\n}\n", len(manySet), structObject, len(manySet), len(manySet)))
+	//[default] columns and [uuid] keys wrap their placeholder to swap zero values for the default
+	for j, val := range insertVals {
+		if param := "$" + strconv.Itoa(j+1); val != param {
+			wrapped := strings.SplitN(val, param, 2)
+			buffer.WriteString(fmt.Sprintf("placeholders[%d] = \"%s\" + placeholders[%d] + \"%s\"\n", j, wrapped[0], j, wrapped[1]))
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("values = append(values, \"(\"+strings.Join(placeholders, \", \")+\")\")\nargs = append(args, %s)\n}\n", strings.Join(manyVars, ", ")))
+	manyInsert := fmt.Sprintf("\"INSERT INTO %s (%s) VALUES \"+strings.Join(values, \", \")", tablePathName, strings.Join(manySet, ", "))
+	if len(returnedCols) == 0 {
+		buffer.WriteString(fmt.Sprintf("if _, err = txn.Exec(%s, args...); err != nil {\nreturn fail(err)\n}\n}\n", manyInsert))
+	} else {
+		//the returned values are kept apart until the transaction is committed
+		var returnedScan []string
+		for _, col := range primCols {
+			returnedScan = append(returnedScan, "&row."+col.varName)
+		}
+		for _, col := range returnedCols {
+			returnedScan = append(returnedScan, "&row."+col.varName)
+		}
+		buffer.WriteString(fmt.Sprintf("rows, err := txn.Query(%s+\" RETURNING %s\", args...)\nif err != nil {\nreturn fail(err)\n}\n", manyInsert, insertReturning))
+		buffer.WriteString(fmt.Sprintf("for rows.Next() {\nrow := new(%s)\nif err = rows.Scan(%s); err != nil {\nrows.Close()\nreturn fail(err)\n}\nreturned = append(returned, row)\n}\nrows.Close()\nif err = rows.Err(); err != nil {\nreturn fail(err)\n}\n}\n", structFromFile.structName, strings.Join(returnedScan, ", ")))
+	}
+	buffer.WriteString("if err = txn.Commit(); err != nil {\nreturn fail(err)\n}\n")
+	if len(returnedCols) > 0 {
+		var assigns []string
+		for _, col := range returnedCols {
+			assigns = append(assigns, fmt.Sprintf("%s.%s = row.%s\n", structObject, col.varName, col.varName))
+		}
+		buffer.WriteString(fmt.Sprintf("for _, row := range returned {\nif %s, found := byKey[%s]; found {\n%s}\n}\n", structObject, keyExpr("row"), strings.Join(assigns, "")))
+	}
+	buffer.WriteString("return nil\n}\n\n")
+
+	//Write CopyInObjects()
+	var copyCols []string
+	copyVars := insertVars
+	if primCol.Sequenced() {
+		copyCols = append(copyCols, "\""+primColName+"\"")
+		copyVars = append([]string{structObject + "." + primVarName}, insertVars...)
+	}
+	for _, colName := range insertSet {
+		copyCols = append(copyCols, "\""+colName+"\"")
+	}
+	keyNote := fmt.Sprintf("//%ss are reserved from the sequence first so they can be filled in\n", primVarName)
+	if primCol.GeneratedKey() {
+		keyNote = fmt.Sprintf("//Zero %ss are generated first so they can be filled in\n", primVarName)
+	} else if !primCol.Sequenced() {
+		keyNote = ""
+	}
+	buffer.WriteString(fmt.Sprintf("//Insert a large number of %s objects to DB with COPY in one transaction\n%sfunc CopyIn%ss(%ss []*%s) error {\n", structFromFile.structName, keyNote, structFromFile.structName, structObject, structFromFile.structName))
+	buffer.WriteString(fmt.Sprintf("txn, err := %s.Begin()\nif err != nil {\nlog.Println(err.Error())\nreturn err\n}\n", dbVar))
+	if primCol.Sequenced() || primCol.GeneratedKey() {
+		keyed := structObject + "s"
+		idQuery := fmt.Sprintf("SELECT %s FROM generate_series(1, $1)", structFromFile.NextvalExpr(primCol))
+		if primCol.GeneratedKey() {
+			//only rows without a key get a new one
+			keyed = "unkeyed"
+			idQuery = "SELECT gen_random_uuid() FROM generate_series(1, $1)"
+			buffer.WriteString(fmt.Sprintf("var unkeyed []*%s\nfor _, %s := range %ss {\nif %s.%s == %s {\nunkeyed = append(unkeyed, %s)\n}\n}\n", structFromFile.structName, structObject, structObject, structObject, primVarName, primCol.ZeroValue(), structObject))
+		}
+		buffer.WriteString(fmt.Sprintf("ids, err := txn.Query(\"%s\", len(%s))\n", idQuery, keyed))
+		buffer.WriteString("if err != nil {\nlog.Println(err.Error())\ntxn.Rollback()\nreturn err\n}\n")
+		buffer.WriteString(fmt.Sprintf("for i := 0; ids.Next() && i < len(%s); i++ {\nif err = ids.Scan(&%s[i].%s); err != nil {\nlog.Println(err.Error())\nids.Close()\ntxn.Rollback()\nreturn err\n}\n}\nids.Close()\n", keyed, keyed, primVarName))
+	}
+	buffer.WriteString(fmt.Sprintf("stmt, err := txn.Prepare(pq.CopyInSchema(\"%s\", \"%s\", %s))\n", structFromFile.schema, structFromFile.tableName, strings.Join(copyCols, ", ")))
+	buffer.WriteString("if err != nil {\nlog.Println(err.Error())\ntxn.Rollback()\nreturn err\n}\n")
+	buffer.WriteString(fmt.Sprintf("for _, %s := range %ss {\nif _, err = stmt.Exec(%s); err != nil {\nlog.Println(err.Error())\nstmt.Close()\ntxn.Rollback()\nreturn err\n}\n}\n", structObject, structObject, strings.Join(copyVars, ", ")))
+	buffer.WriteString("if _, err = stmt.Exec(); err != nil {\nlog.Println(err.Error())\nstmt.Close()\ntxn.Rollback()\nreturn err\n}\n")
+	buffer.WriteString("if err = stmt.Close(); err != nil {\nlog.Println(err.Error())\ntxn.Rollback()\nreturn err\n}\n")
+	//the DB managed columns were left to their defaults, now() is the same for the whole transaction
+	var copyDefaults []string
+	for _, col := range structFromFile.cols {
+		if col.version {
+			copyDefaults = append(copyDefaults, fmt.Sprintf("%s.%s = 1\n", structObject, col.varName))
+		} else if col.createdOn || col.updatedOn {
+			copyDefaults = append(copyDefaults, fmt.Sprintf("%s.%s = now\n", structObject, col.varName))
+		}
+	}
+	if structFromFile.HasTimestampCols() {
+		buffer.WriteString("var now time.Time\nif err = txn.QueryRow(\"SELECT now()::timestamp without time zone\").Scan(&now); err != nil {\nlog.Println(err.Error())\ntxn.Rollback()\nreturn err\n}\n")
+	}
+	buffer.WriteString("if err = txn.Commit(); err != nil {\nlog.Println(err.Error())\nreturn err\n}\n")
+	if len(copyDefaults) > 0 {
+		buffer.WriteString(fmt.Sprintf("for _, %s := range %ss {\n%s}\n", structObject, structObject, strings.Join(copyDefaults, "")))
+	}
+	buffer.WriteString("return nil\n}\n\n")
 
 	//Write Update()
 	buffer.WriteString(fmt.Sprintf("//Update %s object in DB\nfunc (%s *%s) Update() error {\n", structFromFile.structName, structObject, structFromFile.structName))
 	if structFromFile.prepared {
-		buffer.WriteString(fmt.Sprintf("_, err := %s.Update.Exec(%s)\n", dataLayerVar, strings.Join(updateVars, ", ")))
+		buffer.WriteString(updateCall(dataLayerVar+".Update", strings.Join(updateVars, ", ")))
 	} else {
-		buffer.WriteString(fmt.Sprintf("_, err := %sDB.Exec(\"%s\", %s)\n", structFromFile.structName, updateStmt, strings.Join(updateVars, ", ")))
+		buffer.WriteString(updateCall(structFromFile.structName+"DB", fmt.Sprintf("\"%s\", %s", updateStmt, strings.Join(updateVars, ", "))))
+	}
+	buffer.WriteString(fmt.Sprintf("%sreturn nil\n}\n\n", snapshot))
+
+	//Write Upsert() if needed
+	if structFromFile.hasUpsert {
+		buffer.WriteString(fmt.Sprintf("//Insert %s object to DB or update the row it conflicts with, then refresh it from the DB\nfunc (%s *%s) Upsert() error {\n", structFromFile.structName, structObject, structFromFile.structName))
+		if structFromFile.prepared {
+			buffer.WriteString(fmt.Sprintf("row := %s.Upsert.QueryRow(%s)\n", dataLayerVar, strings.Join(upsertVars, ", ")))
+		} else {
+			buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s)\n", structFromFile.structName, upsertStmt, strings.Join(upsertVars, ", ")))
+		}
+		buffer.WriteString(fmt.Sprintf("err := row.Scan(%s)\n", strings.Join(objectVars, ", ")))
+		buffer.WriteString(fmt.Sprintf("if err != nil {\nlog.Println(err.Error())\nreturn err\n}\n%sreturn nil\n}\n\n", snapshot))
 	}
-	buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn err\n}\nreturn nil\n}\n\n")
 
 	//Write MarkDeleted() if needed
 	if delColName != "" {
 		buffer.WriteString(fmt.Sprintf("//Mark a row as deleted at a specific time\nfunc (%s *%s) MarkDeleted(del ", structObject, structFromFile.structName))
 		buffer.WriteString(fmt.Sprintf("%s, when %s) error {\n", delColType, delOnColType))
 		if structFromFile.prepared {
-			buffer.WriteString(fmt.Sprintf("_, err := %s.MarkDel.Exec(del, when, %s.%s)\n", dataLayerVar, structObject, primVarName))
+			buffer.WriteString(updateCall(dataLayerVar+".MarkDel", fmt.Sprintf("del, when, %s", strings.Join(primObjArgs, ", "))))
 		} else {
-			buffer.WriteString(fmt.Sprintf("_, err := %sDB.Exec(\"%s\", del, when, %s.%s)\n", structFromFile.structName, markDelStmt, structObject, primVarName))
+			buffer.WriteString(updateCall(structFromFile.structName+"DB", fmt.Sprintf("\"%s\", del, when, %s", markDelStmt, strings.Join(primObjArgs, ", "))))
 		}
-		buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn err\n}\n")
 		buffer.WriteString(fmt.Sprintf("%s.%s = del\n%s.%s = when\n", structObject, delVarName, structObject, delOnVarName))
+		if structFromFile.trackChanges {
+			buffer.WriteString(fmt.Sprintf("if %s.snapshot != nil {\n%s.snapshot.%s = del\n%s.snapshot.%s = when\n}\n", structObject, structObject, delVarName, structObject, delOnVarName))
+		}
 		buffer.WriteString("return nil\n}\n\n")
+
+		//Write Restore()
+		buffer.WriteString(fmt.Sprintf("//Restore a row that was marked as deleted\nfunc (%s *%s) Restore() error {\nreturn %s.MarkDeleted(false, %s)\n}\n\n", structObject, structFromFile.structName, structObject, delOnZero))
+
+		//Write ListDeletedObjects(), CountDeletedObjects() and PurgeDeletedObjectsBefore()
+		buffer.WriteString(fmt.Sprintf("//List %ss marked as deleted a page at a time, most recently deleted first\nfunc ListDeleted%ss(limit int, offset int) ([]*%s, error) {\n", structFromFile.structName, structFromFile.structName, structFromFile.structName))
+		if structFromFile.prepared {
+			buffer.WriteString(fmt.Sprintf("rows, err := %s.ListDeleted.Query(limit, offset)\n", dataLayerVar))
+		} else {
+			buffer.WriteString(fmt.Sprintf("rows, err := %sDB.Query(\"%s\", limit, offset)\n", structFromFile.structName, listDeletedStmt))
+		}
+		buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\n")
+		buffer.WriteString(rowsToSlice)
+		buffer.WriteString(fmt.Sprintf("//Count %ss marked as deleted\nfunc CountDeleted%ss() (int64, error) {\nvar count int64\n", structFromFile.structName, structFromFile.structName))
+		if structFromFile.prepared {
+			buffer.WriteString(fmt.Sprintf("row := %s.CountDeleted.QueryRow()\n", dataLayerVar))
+		} else {
+			buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\")\n", structFromFile.structName, countDeletedStmt))
+		}
+		buffer.WriteString("err := row.Scan(&count)\nif err != nil {\nlog.Println(err.Error())\nreturn 0, err\n}\nreturn count, nil\n}\n\n")
+		buffer.WriteString(fmt.Sprintf("//Permanently remove %ss that were marked as deleted before cutoff, returns how many were removed\nfunc PurgeDeleted%ssBefore(cutoff time.Time) (int64, error) {\n", structFromFile.structName, structFromFile.structName))
+		if structFromFile.prepared {
+			buffer.WriteString(fmt.Sprintf("result, err := %s.PurgeDeleted.Exec(cutoff)\n", dataLayerVar))
+		} else {
+			buffer.WriteString(fmt.Sprintf("result, err := %sDB.Exec(\"%s\", cutoff)\n", structFromFile.structName, purgeDeletedStmt))
+		}
+		buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn 0, err\n}\nreturn result.RowsAffected()\n}\n\n")
 	}
 
 	//Write Delete()
 	buffer.WriteString(fmt.Sprintf("//Delete will remove the matching row from the DB"))
 	buffer.WriteString(fmt.Sprintf("\nfunc (%s *%s) Delete() error {\n", structObject, structFromFile.structName))
 	if structFromFile.prepared {
-		buffer.WriteString(fmt.Sprintf("_, err := %s.Delete.Exec(%s.%s)\n", dataLayerVar, structObject, primVarName))
+		buffer.WriteString(fmt.Sprintf("_, err := %s.Delete.Exec(%s)\n", dataLayerVar, strings.Join(primObjArgs, ", ")))
 	} else {
-		buffer.WriteString(fmt.Sprintf("_, err := %sDB.Exec(\"%s\", %s.%s)\n", structFromFile.structName, delStmt, structObject, primVarName))
+		buffer.WriteString(fmt.Sprintf("_, err := %sDB.Exec(\"%s\", %s)\n", structFromFile.structName, delStmt, strings.Join(primObjArgs, ", ")))
 	}
 	buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn err\n}\nreturn nil\n}\n\n")
 
@@ -291,21 +1093,348 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 		if delColName != "" {
 			delFilter = ", delFilter int"
 		}
-		buffer.WriteString(fmt.Sprintf("func %s(%s %s%s) ([]*%s, error) {\n", method[0], method[2], method[3], delFilter, structFromFile.structName))
+		buffer.WriteString(fmt.Sprintf("func %s(%s%s) ([]*%s, error) {\n", method[0], method[5], delFilter, structFromFile.structName))
 		delFilter = ""
 		if delColName != "" {
 			delFilter = ", deleted1, deleted2"
-			buffer.WriteString("deleted1 := false\ndeleted2 := false\nswitch delFilter {\ncase DELETED" + strings.ToUpper(structFromFile.structName) + ":\ndeleted1 = true\ndeleted2 = true\ncase ALL" + strings.ToUpper(structFromFile.structName) + ":\ndeleted2 = true\n}\n")
+			buffer.WriteString(delSwitch)
 		}
 		if structFromFile.prepared {
-			buffer.WriteString(fmt.Sprintf("rows, err := %s.%s.Query(%s%s)\n", dataLayerVar, method[4], method[2], delFilter))
+			buffer.WriteString(fmt.Sprintf("rows, err := %s.%s.Query(%s%s)\n", dataLayerVar, method[4], method[6], delFilter))
 		} else {
-			buffer.WriteString(fmt.Sprintf("rows, err := %sDB.Query(\"%s\", %s%s)\n", structFromFile.structName, method[1], method[2], delFilter))
+			buffer.WriteString(fmt.Sprintf("rows, err := %sDB.Query(\"%s\", %s%s)\n", structFromFile.structName, method[1], method[6], delFilter))
 		}
 		buffer.WriteString("if err != nil {\nrows.Close()\nlog.Println(err.Error())\nreturn nil, err\n}\n")
-		buffer.WriteString(fmt.Sprintf("%ss := []*%s{}\nfor rows.Next() {\n%s := new(%s)\nif err = rows.Scan(%s); err != nil {\n", structObject, structFromFile.structName, structObject, structFromFile.structName, strings.Join(objectVars, ", ")))
-		buffer.WriteString("log.Println(err.Error())\nrows.Close()\nreturn")
-		buffer.WriteString(fmt.Sprintf(" %ss, err\n}\n%ss = append(%ss, %s)\n}\n\nrows.Close()\nreturn %ss, nil\n}\n\n", structObject, structObject, structObject, structObject, structObject))
+		buffer.WriteString(rowsToSlice)
+	}
+
+	//Write GetObjectByUniqueKey
+	for _, method := range uniqueMethods {
+		buffer.WriteString(fmt.Sprintf("//Get the %s matching %s, returns sql.ErrNoRows if there isn't one\n", structFromFile.structName, method[3]))
+		delFilter = ""
+		if delColName != "" {
+			delFilter = ", delFilter int"
+		}
+		buffer.WriteString(fmt.Sprintf("func %s(%s%s) (*%s, error) {\n%s := new(%s)\n", method[0], method[2], delFilter, structFromFile.structName, structObject, structFromFile.structName))
+		delFilter = ""
+		if delColName != "" {
+			delFilter = ", deleted1, deleted2"
+			buffer.WriteString(delSwitch)
+		}
+		if structFromFile.prepared {
+			buffer.WriteString(fmt.Sprintf("row := %s.%s.QueryRow(%s%s)\n", dataLayerVar, method[4], method[5], delFilter))
+		} else {
+			buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s%s)\n", structFromFile.structName, method[1], method[5], delFilter))
+		}
+		buffer.WriteString(fmt.Sprintf("err := row.Scan(%s)\n", strings.Join(objectVars, ", ")))
+		buffer.WriteString(fmt.Sprintf("if err != nil {\nif err != sql.ErrNoRows {\nlog.Println(err.Error())\n}\nreturn nil, err\n}\n%sreturn %s, nil\n}\n\n", snapshot, structObject))
+	}
+
+	//Write CountObjects() and CountObjectsByColumn
+	delFilter = ""
+	if delColName != "" {
+		delFilter = "delFilter int"
+	}
+	buffer.WriteString(fmt.Sprintf("//Count %ss in the DB\nfunc Count%ss(%s) (int64, error) {\n", structFromFile.structName, structFromFile.structName, delFilter))
+	delFilter = ""
+	if delColName != "" {
+		delFilter = "deleted1, deleted2"
+		buffer.WriteString(delSwitch)
+	}
+	buffer.WriteString("var count int64\n")
+	if structFromFile.prepared {
+		buffer.WriteString(fmt.Sprintf("row := %s.Count.QueryRow(%s)\n", dataLayerVar, delFilter))
+	} else if delFilter != "" {
+		buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s)\n", structFromFile.structName, countStmt, delFilter))
+	} else {
+		buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\")\n", structFromFile.structName, countStmt))
+	}
+	buffer.WriteString("err := row.Scan(&count)\nif err != nil {\nlog.Println(err.Error())\nreturn 0, err\n}\nreturn count, nil\n}\n\n")
+	for _, method := range countMethods {
+		buffer.WriteString(fmt.Sprintf("//Count %ss by %s\n", structFromFile.structName, method[2]))
+		delFilter = ""
+		if delColName != "" {
+			delFilter = ", delFilter int"
+		}
+		buffer.WriteString(fmt.Sprintf("func %s(%s %s%s) (int64, error) {\n", method[0], method[2], method[3], delFilter))
+		delFilter = ""
+		if delColName != "" {
+			delFilter = ", deleted1, deleted2"
+			buffer.WriteString(delSwitch)
+		}
+		buffer.WriteString("var count int64\n")
+		if structFromFile.prepared {
+			buffer.WriteString(fmt.Sprintf("row := %s.%s.QueryRow(%s%s)\n", dataLayerVar, method[4], method[5], delFilter))
+		} else {
+			buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s%s)\n", structFromFile.structName, method[1], method[5], delFilter))
+		}
+		buffer.WriteString("err := row.Scan(&count)\nif err != nil {\nlog.Println(err.Error())\nreturn 0, err\n}\nreturn count, nil\n}\n\n")
+	}
+
+	//Write ObjectExists()
+	delFilter = ""
+	if delColName != "" {
+		delFilter = ", delFilter int"
+	}
+	buffer.WriteString(fmt.Sprintf("//Check if a %s exists in the DB without loading it\nfunc %sExists(%s%s) (bool, error) {\n", structFromFile.structName, structFromFile.structName, strings.Join(primParams, ", "), delFilter))
+	delFilter = ""
+	if delColName != "" {
+		delFilter = ", deleted1, deleted2"
+		buffer.WriteString(delSwitch)
+	}
+	buffer.WriteString("var exists bool\n")
+	if structFromFile.prepared {
+		buffer.WriteString(fmt.Sprintf("row := %s.Exists.QueryRow(%s%s)\n", dataLayerVar, strings.Join(primArgs, ", "), delFilter))
+	} else {
+		buffer.WriteString(fmt.Sprintf("row := %sDB.QueryRow(\"%s\", %s%s)\n", structFromFile.structName, existsStmt, strings.Join(primArgs, ", "), delFilter))
+	}
+	buffer.WriteString("err := row.Scan(&exists)\nif err != nil {\nlog.Println(err.Error())\nreturn false, err\n}\nreturn exists, nil\n}\n\n")
+
+	//Write List constants and ListObjects()
+	buffer.WriteString(fmt.Sprintf("//Constants used to choose the ORDER BY of List%ss\nconst (\n", structFromFile.structName))
+	for _, method := range listMethods {
+		buffer.WriteString(fmt.Sprintf("%s = iota\n", method[0]))
+	}
+	buffer.WriteString(")\n\n")
+	delFilter = ""
+	if delColName != "" {
+		delFilter = ", delFilter int"
+	}
+	buffer.WriteString(fmt.Sprintf("//List %ss a page at a time using limit and offset\nfunc List%ss(orderBy int, limit int, offset int%s) ([]*%s, error) {\n", structFromFile.structName, structFromFile.structName, delFilter, structFromFile.structName))
+	delFilter = ""
+	if delColName != "" {
+		delFilter = "deleted1, deleted2, "
+		buffer.WriteString(delSwitch)
+	}
+	buffer.WriteString("var rows *sql.Rows\nvar err error\nswitch orderBy {\n")
+	for _, method := range listMethods {
+		buffer.WriteString(fmt.Sprintf("case %s:\n", method[0]))
+		if structFromFile.prepared {
+			buffer.WriteString(fmt.Sprintf("rows, err = %s.%s.Query(%slimit, offset)\n", dataLayerVar, method[2], delFilter))
+		} else {
+			buffer.WriteString(fmt.Sprintf("rows, err = %sDB.Query(\"%s\", %slimit, offset)\n", structFromFile.structName, method[1], delFilter))
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("default:\nreturn nil, fmt.Errorf(\"List%ss: unknown orderBy value %%d\", orderBy)\n}\n", structFromFile.structName))
+	buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\n")
+	buffer.WriteString(rowsToSlice)
+
+	//Write ListObjectsAfter()
+	delFilter = ""
+	if delColName != "" {
+		delFilter = ", delFilter int"
+	}
+	afterParams := "after " + primVarType
+	afterArgs := "after"
+	afterName := primVarName
+	if keyN > 1 {
+		//a composite key takes an after value for each of its columns
+		afterParams = ""
+		afterArgs = ""
+		for i, col := range primCols {
+			if i > 0 {
+				afterParams += ", "
+				afterArgs += ", "
+			}
+			afterParams += "after" + col.varName + " " + col.goType
+			afterArgs += "after" + col.varName
+		}
+		afterName = "(" + strings.Join(primArgs, ", ") + ")"
+	}
+	buffer.WriteString(fmt.Sprintf("//List %ss with a %s greater than after (keyset pagination)\nfunc List%ssAfter(%s, limit int%s) ([]*%s, error) {\n", structFromFile.structName, afterName, structFromFile.structName, afterParams, delFilter, structFromFile.structName))
+	delFilter = ""
+	if delColName != "" {
+		delFilter = "deleted1, deleted2, "
+		buffer.WriteString(delSwitch)
+	}
+	if structFromFile.prepared {
+		buffer.WriteString(fmt.Sprintf("rows, err := %s.ListAfter.Query(%s, %slimit)\n", dataLayerVar, afterArgs, delFilter))
+	} else {
+		buffer.WriteString(fmt.Sprintf("rows, err := %sDB.Query(\"%s\", %s, %slimit)\n", structFromFile.structName, listAfterStmt, afterArgs, delFilter))
+	}
+	buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\n")
+	buffer.WriteString(rowsToSlice)
+
+	//Write the query builder
+	queryBuilder := structFromFile.structName + "QueryBuilder"
+	buffer.WriteString(fmt.Sprintf("//%s builds parameterized queries for %ss\ntype %s struct {\nwhere []string\nargs []interface{}\norderBy []string\nlimit int\noffset int\n}\n\n", queryBuilder, structFromFile.structName, queryBuilder))
+	buffer.WriteString(fmt.Sprintf("//Start a new %s\nfunc %sQuery() *%s {\nreturn &%s{}\n}\n\n", queryBuilder, structFromFile.structName, queryBuilder, queryBuilder))
+	buffer.WriteString(fmt.Sprintf("//Add a WHERE condition, each ? is replaced with the next parameter number\nfunc (q *%s) addWhere(condition string, args ...interface{}) *%s {\n", queryBuilder, queryBuilder))
+	buffer.WriteString("for _, arg := range args {\nq.args = append(q.args, arg)\ncondition = strings.Replace(condition, \"?\", \"$\"+strconv.Itoa(len(q.args)), 1)\n}\nq.where = append(q.where, condition)\nreturn q\n}\n\n")
+	for _, col := range structFromFile.cols {
+		predicates := col.QueryPredicates()
+		if len(predicates) == 0 && !col.nulls {
+			continue
+		}
+		argType := col.goType
+		if col.baseType != "" {
+			argType = col.baseType
+		}
+		varName := UpperCaseFirstChar(col.varName)
+		for _, predicate := range predicates {
+			buffer.WriteString(fmt.Sprintf("//Filter by %s %s value\nfunc (q *%s) %s%s(value %s) *%s {\nreturn q.addWhere(\"%s %s ?\", value)\n}\n\n", col.colName, predicate[1], queryBuilder, varName, predicate[0], argType, queryBuilder, col.colName, predicate[1]))
+		}
+		if len(predicates) > 0 && strings.ToLower(argType) != "bool" {
+			//the array parameter is text[] unless it is cast to the enum's array type
+			anyCast := ""
+			if col.enum != nil {
+				anyCast = "::" + col.dbType + "[]"
+			}
+			buffer.WriteString(fmt.Sprintf("//Filter by %s matching any of values\nfunc (q *%s) %sIn(values ...%s) *%s {\nif len(values) == 0 {\nreturn q.addWhere(\"false\")\n}\n", col.colName, queryBuilder, varName, argType, queryBuilder))
+			buffer.WriteString(fmt.Sprintf("args := make([]interface{}, len(values))\nfor i, value := range values {\nargs[i] = value\n}\nreturn q.addWhere(\"%s IN (?\"+strings.Repeat(\", ?\", len(values)-1)+\")\", args...)\n}\n\n", col.colName))
+			buffer.WriteString(fmt.Sprintf("//Filter by %s matching any of values, sent as one array parameter\nfunc (q *%s) %sAny(values []%s) *%s {\nreturn q.addWhere(\"%s = ANY(?%s)\", pq.Array(values))\n}\n\n", col.colName, queryBuilder, varName, argType, queryBuilder, col.colName, anyCast))
+		}
+		if col.nulls {
+			buffer.WriteString(fmt.Sprintf("//Filter by %s IS NULL\nfunc (q *%s) %sIsNull() *%s {\nreturn q.addWhere(\"%s IS NULL\")\n}\n\n", col.colName, queryBuilder, varName, queryBuilder, col.colName))
+			buffer.WriteString(fmt.Sprintf("//Filter by %s IS NOT NULL\nfunc (q *%s) %sIsNotNull() *%s {\nreturn q.addWhere(\"%s IS NOT NULL\")\n}\n\n", col.colName, queryBuilder, varName, queryBuilder, col.colName))
+		}
+		if len(predicates) > 0 {
+			buffer.WriteString(fmt.Sprintf("//Order by %s\nfunc (q *%s) OrderBy%s() *%s {\nq.orderBy = append(q.orderBy, \"%s\")\nreturn q\n}\n\n", col.colName, queryBuilder, varName, queryBuilder, col.colName))
+			buffer.WriteString(fmt.Sprintf("//Order by %s descending\nfunc (q *%s) OrderBy%sDesc() *%s {\nq.orderBy = append(q.orderBy, \"%s DESC\")\nreturn q\n}\n\n", col.colName, queryBuilder, varName, queryBuilder, col.colName))
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("//Return at most n rows\nfunc (q *%s) Limit(n int) *%s {\nq.limit = n\nreturn q\n}\n\n", queryBuilder, queryBuilder))
+	buffer.WriteString(fmt.Sprintf("//Skip the first n rows\nfunc (q *%s) Offset(n int) *%s {\nq.offset = n\nreturn q\n}\n\n", queryBuilder, queryBuilder))
+	buffer.WriteString(fmt.Sprintf("//SQL returns the built query and its parameters\nfunc (q *%s) SQL() (string, []interface{}) {\nargs := append([]interface{}{}, q.args...)\nquery := \"SELECT %s FROM %s\"\n", queryBuilder, strings.Join(selectVals, ", "), tablePathName))
+	buffer.WriteString("if len(q.where) > 0 {\nquery += \" WHERE \" + strings.Join(q.where, \" AND \")\n}\nif len(q.orderBy) > 0 {\nquery += \" ORDER BY \" + strings.Join(q.orderBy, \", \")\n}\n")
+	buffer.WriteString("if q.limit > 0 {\nargs = append(args, q.limit)\nquery += \" LIMIT $\" + strconv.Itoa(len(args))\n}\nif q.offset > 0 {\nargs = append(args, q.offset)\nquery += \" OFFSET $\" + strconv.Itoa(len(args))\n}\nreturn query, args\n}\n\n")
+	buffer.WriteString(fmt.Sprintf("//Run the query and return all matching %ss\nfunc (q *%s) All(ctx context.Context) ([]*%s, error) {\nquery, args := q.SQL()\nrows, err := %s.QueryContext(ctx, query, args...)\n", structFromFile.structName, queryBuilder, structFromFile.structName, dbVar))
+	buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\n")
+	buffer.WriteString(rowsToSlice)
+	buffer.WriteString(fmt.Sprintf("//Run the query and return the first matching %s\nfunc (q *%s) First(ctx context.Context) (*%s, error) {\nfirst := *q\nfirst.limit = 1\nquery, args := first.SQL()\n", structFromFile.structName, queryBuilder, structFromFile.structName))
+	buffer.WriteString(fmt.Sprintf("%s := new(%s)\nrow := %s.QueryRowContext(ctx, query, args...)\nerr := row.Scan(%s)\n", structObject, structFromFile.structName, dbVar, strings.Join(objectVars, ", ")))
+	buffer.WriteString(fmt.Sprintf("if err != nil {\nlog.Println(err.Error())\nreturn nil, err\n}\n%sreturn %s, nil\n}\n\n", snapshot, structObject))
+
+	//Write loaders for [references] columns that point at structs from the same file
+	refCnt := make(map[*structToCreate]int)
+	for _, col := range structFromFile.cols {
+		if col.refStruc != nil {
+			refCnt[col.refStruc]++
+		}
+	}
+	for _, col := range structFromFile.cols {
+		refCol := col.RefCol()
+		if refCol == nil {
+			continue
+		}
+		ref := col.refStruc
+		refObject := LowerCaseFirstChar(ref.structName)
+		refName := col.RefName()
+		refKeyType := refCol.ArgType()
+		colArgType := col.ArgType()
+		//the referenced rows and the referencing rows skip rows marked as deleted
+		refDelFilter := ""
+		for _, otherCol := range ref.cols {
+			if otherCol.deleted {
+				refDelFilter = "." + UpperCaseFirstChar(otherCol.varName) + "Eq(false)"
+			}
+		}
+		delFilter = ""
+		if delVarName != "" {
+			delFilter = "." + UpperCaseFirstChar(delVarName) + "Eq(false)"
+		}
+		//a struct with several columns referencing the same struct gets one reverse loader per column
+		reverseName := structFromFile.structName + "s"
+		if refCnt[ref] > 1 {
+			reverseName = refName + reverseName
+		}
+		refDelNote := ""
+		if refDelFilter != "" {
+			refDelNote = ", rows marked as deleted aren't returned"
+		}
+		delNote := ""
+		if delFilter != "" {
+			delNote = ", rows marked as deleted aren't returned"
+		}
+		colValid := ""
+		refValid := ""
+		if col.nulls {
+			colValid = fmt.Sprintf("if %s {\n%%s\n}\n", col.IsNullExpr(structObject))
+		}
+		if refCol.nulls {
+			refValid = fmt.Sprintf("if %s {\n%%s\n}\n", refCol.IsNullExpr(refObject))
+		}
+
+		//Write obj.Ref()
+		buffer.WriteString(fmt.Sprintf("//Get the %s referenced by %s%s\nfunc (%s *%s) %s(ctx context.Context) (*%s, error) {\n", ref.structName, col.varName, refDelNote, structObject, structFromFile.structName, refName, ref.structName))
+		if colValid != "" {
+			buffer.WriteString(fmt.Sprintf(colValid, "return nil, nil"))
+		}
+		buffer.WriteString(fmt.Sprintf("return %sQuery().%sEq(%s(%s))%s.First(ctx)\n}\n\n", ref.structName, UpperCaseFirstChar(refCol.varName), refKeyType, col.ValueExpr(structObject), refDelFilter))
+
+		//Write ref.Objects()
+		buffer.WriteString(fmt.Sprintf("//Get the %ss whose %s references the %s%s\nfunc (%s *%s) %s(ctx context.Context) ([]*%s, error) {\n", structFromFile.structName, col.varName, ref.structName, delNote, refObject, ref.structName, reverseName, structFromFile.structName))
+		if refValid != "" {
+			buffer.WriteString(fmt.Sprintf(refValid, fmt.Sprintf("return []*%s{}, nil", structFromFile.structName)))
+		}
+		buffer.WriteString(fmt.Sprintf("return %sQuery().%sEq(%s(%s))%s%s.All(ctx)\n}\n\n", structFromFile.structName, UpperCaseFirstChar(col.varName), colArgType, refCol.ValueExpr(refObject), delFilter, primOrderBy))
+
+		//Write LoadRefsForObjects()
+		buffer.WriteString(fmt.Sprintf("//Load the %ss referenced by the %s of each %s with one query, keyed by %s\nfunc Load%ssFor%ss(ctx context.Context, %ss []*%s) (map[%s]*%s, error) {\n", ref.structName, col.varName, structFromFile.structName, refCol.varName, refName, structFromFile.structName, structObject, structFromFile.structName, refKeyType, ref.structName))
+		buffer.WriteString(fmt.Sprintf("seen := make(map[%s]bool)\nvar ids []%s\nfor _, %s := range %ss {\n", refKeyType, refKeyType, structObject, structObject))
+		if colValid != "" {
+			buffer.WriteString(fmt.Sprintf(colValid, "continue"))
+		}
+		buffer.WriteString(fmt.Sprintf("if id := %s(%s); !seen[id] {\nseen[id] = true\nids = append(ids, id)\n}\n}\n", refKeyType, col.ValueExpr(structObject)))
+		buffer.WriteString(fmt.Sprintf("loaded := make(map[%s]*%s, len(ids))\nif len(ids) == 0 {\nreturn loaded, nil\n}\n", refKeyType, ref.structName))
+		buffer.WriteString(fmt.Sprintf("found, err := %sQuery().%sAny(ids)%s.All(ctx)\nif err != nil {\nreturn nil, err\n}\nfor _, %s := range found {\n", ref.structName, UpperCaseFirstChar(refCol.varName), refDelFilter, refObject))
+		if refValid != "" {
+			buffer.WriteString(fmt.Sprintf(refValid, "continue"))
+		}
+		buffer.WriteString(fmt.Sprintf("loaded[%s] = %s\n}\nreturn loaded, nil\n}\n\n", refCol.ValueExpr(refObject), refObject))
+
+		//Write LoadObjectsForRefs()
+		buffer.WriteString(fmt.Sprintf("//Load the %ss that reference each %s by %s with one query, keyed by %s\nfunc Load%sFor%ss(ctx context.Context, %ss []*%s) (map[%s][]*%s, error) {\n", structFromFile.structName, ref.structName, col.varName, refCol.varName, reverseName, ref.structName, refObject, ref.structName, refKeyType, structFromFile.structName))
+		buffer.WriteString(fmt.Sprintf("var ids []%s\nfor _, %s := range %ss {\n", colArgType, refObject, refObject))
+		if refValid != "" {
+			buffer.WriteString(fmt.Sprintf(refValid, "continue"))
+		}
+		buffer.WriteString(fmt.Sprintf("ids = append(ids, %s(%s))\n}\n", colArgType, refCol.ValueExpr(refObject)))
+		buffer.WriteString(fmt.Sprintf("loaded := make(map[%s][]*%s)\nif len(ids) == 0 {\nreturn loaded, nil\n}\n", refKeyType, structFromFile.structName))
+		buffer.WriteString(fmt.Sprintf("found, err := %sQuery().%sAny(ids)%s%s.All(ctx)\nif err != nil {\nreturn nil, err\n}\nfor _, %s := range found {\n", structFromFile.structName, UpperCaseFirstChar(col.varName), delFilter, primOrderBy, structObject))
+		if colValid != "" {
+			buffer.WriteString(fmt.Sprintf(colValid, "continue"))
+		}
+		buffer.WriteString(fmt.Sprintf("key := %s(%s)\nloaded[key] = append(loaded[key], %s)\n}\nreturn loaded, nil\n}\n\n", refKeyType, col.ValueExpr(structObject), structObject))
+	}
+
+	//Write AddOther(), RemoveOther(), Others() and ObjectsWithOther() for [many to many] lines
+	for _, join := range structFromFile.joins {
+		other := join.structs[0]
+		if other == structFromFile {
+			other = join.structs[1]
+		}
+		otherObject := LowerCaseFirstChar(other.structName)
+		otherPrim := other.PrimaryCol()
+		stmts := joinStmts[join]
+		otherDelFilter := ""
+		for _, otherCol := range other.cols {
+			if otherCol.deleted {
+				otherDelFilter = "." + UpperCaseFirstChar(otherCol.varName) + "Eq(false)"
+			}
+		}
+		delFilter = ""
+		if delVarName != "" {
+			delFilter = "." + UpperCaseFirstChar(delVarName) + "Eq(false)"
+		}
+		for i, action := range []string{"Add", "Remove"} {
+			if action == "Add" {
+				buffer.WriteString(fmt.Sprintf("//Link the %s to the %s, linking it again does nothing\n", other.structName, structFromFile.structName))
+			} else {
+				buffer.WriteString(fmt.Sprintf("//Unlink the %s from the %s\n", other.structName, structFromFile.structName))
+			}
+			buffer.WriteString(fmt.Sprintf("func (%s *%s) %s%s(ctx context.Context, %s *%s) error {\n", structObject, structFromFile.structName, action, other.structName, otherObject, other.structName))
+			if structFromFile.prepared {
+				buffer.WriteString(fmt.Sprintf("_, err := %s.%s%s.ExecContext(ctx, %s.%s, %s.%s)\n", dataLayerVar, action, other.structName, structObject, primVarName, otherObject, otherPrim.varName))
+			} else {
+				buffer.WriteString(fmt.Sprintf("_, err := %s.ExecContext(ctx, \"%s\", %s.%s, %s.%s)\n", dbVar, stmts[i], structObject, primVarName, otherObject, otherPrim.varName))
+			}
+			buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn err\n}\nreturn nil\n}\n\n")
+		}
+		buffer.WriteString(fmt.Sprintf("//Get the %ss linked to the %s\nfunc (%s *%s) %ss(ctx context.Context) ([]*%s, error) {\n", other.structName, structFromFile.structName, structObject, structFromFile.structName, other.structName, other.structName))
+		buffer.WriteString(fmt.Sprintf("return %sQuery().addWhere(\"%s IN (SELECT %s FROM %s WHERE %s = ?)\", %s.%s)%s.OrderBy%s().All(ctx)\n}\n\n", other.structName, otherPrim.colName, stmts[4], stmts[2], stmts[3], structObject, primVarName, otherDelFilter, UpperCaseFirstChar(otherPrim.varName)))
+		buffer.WriteString(fmt.Sprintf("//Get the %ss linked to the %s\nfunc %ssWith%s(ctx context.Context, %s *%s) ([]*%s, error) {\n", structFromFile.structName, other.structName, structFromFile.structName, other.structName, otherObject, other.structName, structFromFile.structName))
+		buffer.WriteString(fmt.Sprintf("return %sQuery().addWhere(\"%s IN (SELECT %s FROM %s WHERE %s = ?)\", %s.%s)%s%s.All(ctx)\n}\n\n", structFromFile.structName, primColName, stmts[3], stmts[2], stmts[4], otherObject, otherPrim.varName, delFilter, primOrderBy))
 	}
 
 	//Write PatchVar
@@ -313,15 +1442,139 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 		buffer.WriteString(fmt.Sprintf("//Update %s only\n", method[2]))
 		buffer.WriteString(fmt.Sprintf("func (%s *%s) %s(%s %s) error {\n", structObject, structFromFile.structName, method[0], method[2], method[3]))
 		if structFromFile.prepared {
-			buffer.WriteString(fmt.Sprintf("_, err := %s.%s.Exec(%s, %s.%s)\n", dataLayerVar, method[4], method[2], structObject, primVarName))
+			buffer.WriteString(updateCall(dataLayerVar+"."+method[4], fmt.Sprintf("%s, %s", method[6], strings.Join(primObjArgs, ", "))))
 		} else {
-			buffer.WriteString(fmt.Sprintf("_, err := %sDB.Exec(\"%s\", %s, %s.%s)\n", structFromFile.structName, method[1], method[2], structObject, primVarName))
+			buffer.WriteString(updateCall(structFromFile.structName+"DB", fmt.Sprintf("\"%s\", %s, %s", method[1], method[6], strings.Join(primObjArgs, ", "))))
 		}
-		buffer.WriteString("if err != nil {\nlog.Println(err.Error())\nreturn err\n}\n")
 		buffer.WriteString(fmt.Sprintf("%s.%s = %s\n", structObject, method[5], method[2]))
+		if structFromFile.trackChanges {
+			buffer.WriteString(fmt.Sprintf("if %s.snapshot != nil {\n%s.snapshot.%s = %s\n}\n", structObject, structObject, method[5], method[2]))
+		}
 		buffer.WriteString("return nil\n}\n\n")
 	}
 
+	//Write PatchGroup
+	for i, group := range structFromFile.PatchGroups() {
+		var groupParams []string
+		var groupArgs []string
+		var groupVars []string
+		var groupAssign []string
+		for _, col := range structFromFile.PatchGroupCols(group) {
+			groupParams = append(groupParams, LowerCaseFirstChar(col.varName)+" "+col.goType)
+			groupArgs = append(groupArgs, LowerCaseFirstChar(col.varName))
+			groupVars = append(groupVars, argVar(col, LowerCaseFirstChar(col.varName)))
+			groupAssign = append(groupAssign, fmt.Sprintf("%s.%s = %s\n", structObject, col.varName, LowerCaseFirstChar(col.varName)))
+		}
+		buffer.WriteString(fmt.Sprintf("//Update %s only\n", strings.Join(groupArgs, ", ")))
+		buffer.WriteString(fmt.Sprintf("func (%s *%s) Patch%s(%s) error {\n", structObject, structFromFile.structName, UpperCaseFirstChar(group), strings.Join(groupParams, ", ")))
+		if structFromFile.prepared {
+			buffer.WriteString(updateCall(dataLayerVar+".Patch"+UpperCaseFirstChar(group), fmt.Sprintf("%s, %s", strings.Join(groupVars, ", "), strings.Join(primObjArgs, ", "))))
+		} else {
+			buffer.WriteString(updateCall(structFromFile.structName+"DB", fmt.Sprintf("\"%s\", %s, %s", patchGroupStmts[i], strings.Join(groupVars, ", "), strings.Join(primObjArgs, ", "))))
+		}
+		buffer.WriteString(strings.Join(groupAssign, ""))
+		if structFromFile.trackChanges {
+			buffer.WriteString(fmt.Sprintf("if %s.snapshot != nil {\n%s}\n", structObject, strings.Replace(strings.Join(groupAssign, ""), structObject+".", structObject+".snapshot.", -1)))
+		}
+		buffer.WriteString("return nil\n}\n\n")
+	}
+
+	//Write change tracking methods, slices and maps are copied so the snapshot doesn't share them
+	deepCopy := func(col *column, dst string, src string) string {
+		switch {
+		case col.goType == "[]byte" || col.ValueKind() == "array" || col.goType == "json.RawMessage" || col.goType == "net.IP":
+			return fmt.Sprintf("%s.%s = append(%s(nil), %s.%s...)\n", dst, col.varName, col.goType, src, col.varName)
+		case strings.HasPrefix(col.goType, "map["):
+			//values nested in the map are still shared
+			return fmt.Sprintf("%s.%s = make(%s, len(%s.%s))\nfor key, value := range %s.%s {\n%s.%s[key] = value\n}\n", dst, col.varName, col.goType, src, col.varName, src, col.varName, dst, col.varName)
+		case col.baseType == "[]byte" && (col.nullStyle == "nulls" || col.nullStyle == "sql"):
+			field := col.NullValueField()
+			return fmt.Sprintf("%s.%s.%s = append([]byte(nil), %s.%s.%s...)\n", dst, col.varName, field, src, col.varName, field)
+		case col.baseType == "[]byte" && col.nullStyle == "pointer":
+			return fmt.Sprintf("if %s.%s != nil {\nvalue := append([]byte(nil), *%s.%s...)\n%s.%s = &value\n}\n", src, col.varName, src, col.varName, dst, col.varName)
+		case (col.jsonb && strings.HasPrefix(col.goType, "*")) || col.nullStyle == "pointer":
+			//copy the value pointed to, the slices and maps in it are still shared
+			return fmt.Sprintf("if %s.%s != nil {\nvalue := *%s.%s\n%s.%s = &value\n}\n", src, col.varName, src, col.varName, dst, col.varName)
+		}
+		return ""
+	}
+	if structFromFile.trackChanges {
+		var nonPrimCols []string
+		for _, col := range structFromFile.cols {
+			if !col.primary && !col.DBManaged() {
+				nonPrimCols = append(nonPrimCols, "\""+col.colName+"\"")
+			}
+		}
+		buffer.WriteString(fmt.Sprintf("//Remember the current values so Changed() can compare against them\nfunc (%s *%s) takeSnapshot() {\nsnapshot := *%s\nsnapshot.snapshot = nil\n", structObject, structFromFile.structName, structObject))
+		for _, col := range structFromFile.cols {
+			buffer.WriteString(deepCopy(col, "snapshot", structObject))
+		}
+		buffer.WriteString(fmt.Sprintf("%s.snapshot = &snapshot\n}\n\n", structObject))
+
+		buffer.WriteString(fmt.Sprintf("//Changed returns the columns that differ from when the %s was loaded or saved\n//All columns are returned if the %s didn't come from the DB\nfunc (%s *%s) Changed() []string {\n", structFromFile.structName, structFromFile.structName, structObject, structFromFile.structName))
+		buffer.WriteString(fmt.Sprintf("if %s.snapshot == nil {\nreturn []string{%s}\n}\nvar changed []string\n", structObject, strings.Join(nonPrimCols, ", ")))
+		for _, col := range structFromFile.cols {
+			if !col.primary && !col.DBManaged() {
+				buffer.WriteString(fmt.Sprintf("if %s {\nchanged = append(changed, \"%s\")\n}\n", col.ChangedExpr(structObject, structObject+".snapshot"), col.colName))
+			}
+		}
+		buffer.WriteString("return changed\n}\n\n")
+
+		buffer.WriteString(fmt.Sprintf("//Update only the columns that changed since the %s was loaded, returns the columns that were updated\nfunc (%s *%s) UpdateChanged() ([]string, error) {\nchanged := %s.Changed()\nif len(changed) == 0 {\nreturn changed, nil\n}\n", structFromFile.structName, structObject, structFromFile.structName, structObject))
+		buffer.WriteString(fmt.Sprintf("if err := %s.updateColumns(changed); err != nil {\nreturn nil, err\n}\n//every column was written if there was nothing to compare against\nif %s.snapshot == nil {\n%s}\nreturn changed, nil\n}\n\n", structObject, structObject, snapshot))
+	}
+
+	//Write ApplyJSONPatch()
+	buffer.WriteString(BuildApplyJSONPatch(structFromFile, structObject))
+
+	//Write updateColumns() used by ApplyJSONPatch() and UpdateChanged()
+	buffer.WriteString(fmt.Sprintf("//Update the named columns with one UPDATE built at run time\nfunc (%s *%s) updateColumns(colNames []string) error {\nset := make([]string, 0, len(colNames))\nargs := make([]interface{}, 0, len(colNames)+1)\nfor _, colName := range colNames {\nswitch colName {\n", structObject, structFromFile.structName))
+	for _, col := range structFromFile.cols {
+		if !col.primary && !col.DBManaged() {
+			buffer.WriteString(fmt.Sprintf("case \"%s\":\nargs = append(args, %s)\n", col.colName, argVar(col, structObject+"."+col.varName)))
+		}
+	}
+	buffer.WriteString(fmt.Sprintf("default:\nreturn fmt.Errorf(\"%s has no updatable column %%s\", colName)\n}\nset = append(set, colName+\" = $\"+strconv.Itoa(len(args)))\n}\n", structFromFile.structName))
+	//the key parts and the [version] are the last parameters
+	whereCols := primColNames
+	whereArgs := primObjArgs
+	if versionColName != "" {
+		whereCols = append(append([]string{}, primColNames...), versionColName)
+		whereArgs = append(append([]string{}, primObjArgs...), structObject+"."+versionVarName)
+	}
+	var updateColsWhere []string
+	for i, colName := range whereCols {
+		argN := "len(args)"
+		if back := len(whereCols) - 1 - i; back > 0 {
+			argN = fmt.Sprintf("len(args)-%d", back)
+		}
+		updateColsWhere = append(updateColsWhere, fmt.Sprintf("%s = $\"+strconv.Itoa(%s)", colName, argN))
+	}
+	buffer.WriteString(fmt.Sprintf("args = append(args, %s)\n", strings.Join(whereArgs, ", ")))
+	updateColsStmt := fmt.Sprintf("\"UPDATE %s SET \"+strings.Join(set, \", \")+\"%s WHERE %s", tablePathName, writeSet, strings.Join(updateColsWhere, "+\" and "))
+	if len(writeReturning) > 0 {
+		buffer.WriteString(fmt.Sprintf("err := %s.QueryRow(%s+\" RETURNING %s\", args...).Scan(%s)\n%s", dbVar, updateColsStmt, strings.Join(writeReturning, ", "), strings.Join(writeScan, ", "), updateErr))
+	} else {
+		buffer.WriteString(fmt.Sprintf("_, err := %s.Exec(%s, args...)\n%s", dbVar, updateColsStmt, updateErr))
+	}
+	if structFromFile.trackChanges {
+		//the written columns are now saved, the rest of the snapshot is left alone
+		buffer.WriteString(fmt.Sprintf("if %s.snapshot != nil {\nfor _, colName := range colNames {\nswitch colName {\n", structObject))
+		for _, col := range structFromFile.cols {
+			if col.primary || col.DBManaged() {
+				continue
+			}
+			buffer.WriteString(fmt.Sprintf("case \"%s\":\n", col.colName))
+			copyStmt := deepCopy(col, structObject+".snapshot", structObject)
+			if copyStmt == "" || col.nulls || col.jsonb {
+				buffer.WriteString(fmt.Sprintf("%s.snapshot.%s = %s.%s\n", structObject, col.varName, structObject, col.varName))
+			}
+			buffer.WriteString(copyStmt)
+		}
+		buffer.WriteString("}\n}\n}\n")
+	}
+	buffer.WriteString("return nil\n}\n\n")
+
 	//Create DataLayer section if prepared statements are being used
 	if structFromFile.prepared {
 		buffer.WriteString(fmt.Sprintf("//DataLayer is used to store prepared SQL statements\ntype %sDataLayer struct {\n", structFromFile.structName))
@@ -335,6 +1588,9 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 		for _, methodSlc := range patchMethods {
 			buffer.WriteString(fmt.Sprintf("%s *sql.Stmt\n", methodSlc[4]))
 		}
+		for _, stmt := range preparedStmts {
+			buffer.WriteString(fmt.Sprintf("%s *sql.Stmt\n", stmt[0]))
+		}
 		buffer.WriteString("Init bool\n}\n")
 
 		//Write InitDataLayer f() and prepared SQL statements
@@ -353,6 +1609,9 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 		for _, method := range patchMethods {
 			buffer.WriteString(fmt.Sprintf("%s.%s, err = db.Prepare(\"%s\")\n", dataLayerVar, method[4], method[1]))
 		}
+		for _, stmt := range preparedStmts {
+			buffer.WriteString(fmt.Sprintf("%s.%s, err = db.Prepare(\"%s\")\n", dataLayerVar, stmt[0], stmt[1]))
+		}
 		buffer.WriteString(fmt.Sprintf("%s.Init = true\n%s.DB = db\n}\nreturn err\n}\n", dataLayerVar, dataLayerVar))
 		//Write CloseStmts f()
 		buffer.WriteString(fmt.Sprintf("\n//Close%sStmts should be called when prepared SQL statements aren't needed anymore\nfunc Close%sStmts() {\n", structFromFile.structName, structFromFile.structName))
@@ -366,6 +1625,9 @@ func BuildStringForFileWrite(structFromFile *structToCreate, isNew bool, package
 		for _, method := range patchMethods {
 			buffer.WriteString(fmt.Sprintf("%s.%s.Close()\n", dataLayerVar, method[4]))
 		}
+		for _, stmt := range preparedStmts {
+			buffer.WriteString(fmt.Sprintf("%s.%s.Close()\n", dataLayerVar, stmt[0]))
+		}
 		buffer.WriteString(fmt.Sprintf("%s.Init = false\n}\n}\n", dataLayerVar))
 	}
 
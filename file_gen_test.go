@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runGenerated compiles the generated code together with a main function and
+// runs it, the code may only import the standard library
+func runGenerated(t *testing.T, imports string, code string, mainBody string) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go command is needed to run the generated code")
+	}
+	dir := t.TempDir()
+	src := "package main\n\nimport (\n" + imports + ")\n\n" + code + "\nfunc main() {\n" + mainBody + "}\n"
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(goBin, "run", file)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=off", "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("generated code failed: %v\n%s\n%s", err, out, src)
+	}
+}
+
+func TestBuildValueConverter(t *testing.T) {
+	code := BuildValueConverter("Host", "hostValue", map[string]bool{"jsonb": true, "interval": true, "inet": true})
+	imports := "\"database/sql/driver\"\n\"encoding/json\"\n\"fmt\"\n\"net\"\n\"reflect\"\n\"strconv\"\n\"time\"\n"
+	runGenerated(t, imports, code, `var ip net.IP
+if v, err := (hostValue{&ip}).Value(); v != nil || err != nil {
+	panic(fmt.Sprintf("a nil IP was sent as %v, %v", v, err))
+}
+ip = net.ParseIP("10.0.0.1")
+if v, _ := (hostValue{&ip}).Value(); v != "10.0.0.1" {
+	panic(fmt.Sprintf("the IP was sent as %v", v))
+}
+if err := (hostValue{&ip}).Scan(nil); err != nil || ip != nil {
+	panic(fmt.Sprintf("a NULL inet was read as %v, %v", ip, err))
+}
+tags := map[string]int{"old": 1}
+if err := (hostValue{&tags}).Scan(nil); err != nil || tags != nil {
+	panic(fmt.Sprintf("a NULL jsonb was read as %v, %v", tags, err))
+}
+if err := (hostValue{&tags}).Scan([]byte(`+"`"+`{"new":2}`+"`"+`)); err != nil || len(tags) != 1 || tags["new"] != 2 {
+	panic(fmt.Sprintf("the jsonb was read as %v, %v", tags, err))
+}
+uptime := time.Minute
+if err := (hostValue{&uptime}).Scan(nil); err != nil || uptime != 0 {
+	panic(fmt.Sprintf("a NULL interval was read as %v, %v", uptime, err))
+}
+if err := (hostValue{&uptime}).Scan(int64(time.Second)); err != nil || uptime != time.Second {
+	panic(fmt.Sprintf("the interval was read as %v, %v", uptime, err))
+}
+`)
+}
+
+func TestBuildApplyJSONPatch(t *testing.T) {
+	cols := []*column{
+		{varName: "ThingID", colName: "thing_id", goType: "int", primary: true, structLine: "ThingID int `json:\"id\"`"},
+		{varName: "Name", colName: "name", goType: "string", structLine: "Name string `json:\"name\"`"},
+		{varName: "Tags", colName: "tags", goType: "[]string", structLine: "Tags []string `json:\"tags\"`"},
+		{varName: "Prefs", colName: "prefs", goType: "map[string]interface{}", structLine: "Prefs map[string]interface{} `json:\"prefs\"`"},
+		{varName: "Count", colName: "count", goType: "int", structLine: "Count int `json:\"count\"`"},
+	}
+	thing := &structToCreate{structName: "Thing", cols: cols}
+	code := "type Thing struct {\n" + BuildStructFields(cols) + "}\n\n" + BuildApplyJSONPatch(thing, "thing") +
+		"func (thing *Thing) updateColumns(colNames []string) error {\nreturn nil\n}\n"
+	imports := "\"encoding/json\"\n\"fmt\"\n\"log\"\n\"reflect\"\n\"strings\"\n"
+	runGenerated(t, imports, code, `tags := make([]string, 1, 4)
+tags[0] = "a"
+thing := &Thing{ThingID: 1, Name: "n", Tags: tags, Prefs: map[string]interface{}{"a": 1.0, "b": "keep"}, Count: 1}
+want := Thing{ThingID: 1, Name: "n", Tags: []string{"a"}, Prefs: map[string]interface{}{"a": 1.0, "b": "keep"}, Count: 1}
+//the keys are applied in map order, so the bad count is reached at a different point each time
+for i := 0; i < 20; i++ {
+	if _, err := thing.ApplyJSONPatch([]byte(`+"`"+`{"name":"m","tags":["b","c"],"prefs":{"a":null,"c":2},"count":"bad"}`+"`"+`)); err == nil {
+		panic("expected an error for the count")
+	}
+	if !reflect.DeepEqual(*thing, want) || tags[:2][1] != "" {
+		panic(fmt.Sprintf("a failed patch changed the Thing: %+v", *thing))
+	}
+}
+if _, err := thing.ApplyJSONPatch([]byte(`+"`"+`{"prefs":{"a":null,"c":{"d":2}},"tags":["b"]}`+"`"+`)); err != nil {
+	panic(err)
+}
+want.Prefs = map[string]interface{}{"b": "keep", "c": map[string]interface{}{"d": 2.0}}
+want.Tags = []string{"b"}
+if !reflect.DeepEqual(*thing, want) {
+	panic(fmt.Sprintf("the patch was applied as %+v", *thing))
+}
+`)
+}
+
+func TestUpsertVersionReservedTable(t *testing.T) {
+	cols := []*column{
+		{varName: "UserID", colName: "user_id", goType: "int", dbType: "bigint", primary: true, structLine: "UserID int `json:\"id\"`"},
+		{varName: "Email", colName: "email", goType: "string", dbType: "text", upsert: true, structLine: "Email string `json:\"email\"`"},
+		{varName: "Ver", colName: "ver", goType: "int", dbType: "integer", version: true, structLine: "Ver int `json:\"ver\"`"},
+	}
+	user := &structToCreate{structName: "User", tableName: "user", database: "db", schema: "public", cols: cols, hasKey: true, hasUpsert: true}
+	code := BuildStringForFileWrite(user)
+	want := "INSERT INTO db.public.user AS t (email) VALUES ($1) ON CONFLICT (email) DO UPDATE SET email = EXCLUDED.email, ver = t.ver + 1 RETURNING user_id, email, ver"
+	if !strings.Contains(code, want) {
+		t.Errorf("the upsert for a [version] column on the user table is not %q in:\n%s", want, code)
+	}
+}
@@ -2,40 +2,244 @@ package main
 
 import (
 	"fmt"
+	"reflect"
+	"strconv"
 	"strings"
 	"unicode"
 )
 
 type structToCreate struct {
-	cols       []*column
-	oldAltCols []string
-	newAltCols []string
-	oldColPrim string
-	actionType string
-	structName string
-	tableName  string
-	database   string
-	schema     string
-	filePath   string
-	fileName   string
-	hasKey     bool
-	nullsPkg   bool
-	prepared   bool
+	cols         []*column
+	oldAltCols   []string
+	newAltCols   []string
+	oldColPrim   string
+	actionType   string
+	structName   string
+	tableName    string
+	database     string
+	schema       string
+	filePath     string
+	fileName     string
+	upsertVars   []string
+	joins        []*manyToMany
+	hasKey       bool
+	hasUpsert    bool
+	prepared     bool
+	trackChanges bool
+	concurrently bool // [concurrent indexes] builds indexes with CREATE INDEX CONCURRENTLY
+	insertZeros  bool // [insert zeros] inserts zero values instead of [default] expressions
+	identity     bool // [identity] makes the integer key an identity column instead of using a separate sequence
+	checks       []string
+	enums        []*enumType       // [enum] Go types written to the file with this struct
+	isNested     bool              // from [nested struct], a type used by other structs instead of a table
+	nestedTypes  []*structToCreate // [nested struct] types written to the file with this struct
+	owner        *structToCreate   // for a [nested struct], the first struct using it
+}
+
+// enumType is a Postgres ENUM type from an [enum] line, variables of its Go type are stored in it
+type enumType struct {
+	typeName string // Go type, e.g. OrderStatus
+	dbName   string // order_status
+	schema   string
+	values   []string        // labels in the order of the DB type
+	owner    *structToCreate // first struct using the type, its file gets the Go type
+}
+
+// ParseEnum builds an enumType from the words after [enum], the Go type name
+// followed by the values
+func ParseEnum(words []string, useUnderscore bool, schema string) (*enumType, error) {
+	if len(words) < 2 {
+		return nil, fmt.Errorf("[enum] needs a type name and at least one value, e.g. [enum] OrderStatus pending shipped.")
+	}
+	enum := &enumType{typeName: UpperCaseFirstChar(words[0]), schema: schema}
+	if err := CheckColAndTblNames(enum.typeName); err != nil {
+		return nil, fmt.Errorf("[enum] %s: %s", words[0], err.Error())
+	}
+	enum.dbName = strings.ToLower(enum.typeName)
+	if useUnderscore {
+		enum.dbName, _ = ConvertToUnderscore(enum.typeName)
+	}
+	seen := make(map[string]bool)
+	for _, value := range words[1:] {
+		//values become Go constants, so they follow the same rules as column names
+		if err := CheckColAndTblNames(value); err != nil {
+			return nil, fmt.Errorf("[enum] %s value %s: %s", enum.typeName, value, err.Error())
+		}
+		if seen[value] {
+			return nil, fmt.Errorf("[enum] %s lists %s more than once.", enum.typeName, value)
+		}
+		seen[value] = true
+		enum.values = append(enum.values, value)
+	}
+	return enum, nil
+}
+
+// FindEnum returns the enum with the Go type goType, or nil
+func FindEnum(enums []*enumType, goType string) *enumType {
+	for _, enum := range enums {
+		if enum.typeName == goType {
+			return enum
+		}
+	}
+	return nil
+}
+
+// CheckEnums makes sure every [enum] is used by a struct variable, the Go type
+// is written to the file of the first struct using it
+func CheckEnums(enums []*enumType) error {
+	for _, enum := range enums {
+		if enum.owner == nil {
+			return fmt.Errorf("[enum] %s isn't used by any struct variable. [enum] lines have to come before the structs using them.", enum.typeName)
+		}
+	}
+	return nil
+}
+
+// QualifiedName returns the schema qualified type name for DDL and casts
+func (enum *enumType) QualifiedName() string {
+	return AddQuotesIfAnyUpperCase(enum.schema) + "." + enum.dbName
+}
+
+// ConstName returns the Go constant of a value, e.g. OrderStatusOnHold for on_hold
+func (enum *enumType) ConstName(value string) string {
+	return enum.typeName + ConvertToCamel(value)
+}
+
+// EnumTypes returns the enums used by the struct's columns without repeats
+func (struc *structToCreate) EnumTypes() []*enumType {
+	var enums []*enumType
+	for _, col := range struc.cols {
+		if col.enum != nil && FindEnum(enums, col.enum.typeName) == nil {
+			enums = append(enums, col.enum)
+		}
+	}
+	return enums
+}
+
+// FindNested returns the [nested struct] named by goType, or nil
+func FindNested(nested []*structToCreate, goType string) *structToCreate {
+	for _, struc := range nested {
+		if struc.structName == strings.TrimPrefix(goType, "*") {
+			return struc
+		}
+	}
+	return nil
+}
+
+// UseNested records that struc uses the [nested struct] nested. The first
+// struct using it also takes the types the nested struct uses, so they are all
+// written to its file.
+func (struc *structToCreate) UseNested(nested *structToCreate) {
+	if nested.owner != nil {
+		return
+	}
+	nested.owner = struc
+	struc.nestedTypes = append(struc.nestedTypes, nested)
+	for _, inner := range nested.nestedTypes {
+		inner.owner = struc
+		struc.nestedTypes = append(struc.nestedTypes, inner)
+	}
+	for _, enum := range nested.enums {
+		enum.owner = struc
+		struc.enums = append(struc.enums, enum)
+	}
+	nested.nestedTypes = nil
+	nested.enums = nil
+}
+
+// Embed flattens the columns of an embedded [nested struct] into the struct,
+// prefixing the column names, e.g. address_street. The generated code reaches
+// them through Go's promoted fields.
+func (struc *structToCreate) Embed(nested *structToCreate, prefix string) {
+	for _, col := range nested.cols {
+		embedded := *col
+		embedded.colName = prefix + "_" + col.colName
+		embedded.embed = nested
+		struc.cols = append(struc.cols, &embedded)
+	}
+	struc.UseNested(nested)
+}
+
+// CheckNested makes sure embedded columns don't clash with the struct's own
+// and that a [nested struct] doesn't hold the keywords only a table can have
+func (struc *structToCreate) CheckNested() error {
+	varNames := make(map[string]bool)
+	colNames := make(map[string]bool)
+	for _, col := range struc.cols {
+		if varNames[col.varName] || colNames[col.colName] {
+			return fmt.Errorf("%s has more than one %s variable or %s column, check the structs it embeds.", struc.structName, col.varName, col.colName)
+		}
+		varNames[col.varName] = true
+		colNames[col.colName] = true
+		if struc.isNested && (col.primary || col.deleted || col.deletedOn || col.version || col.DBManaged()) {
+			return fmt.Errorf("%s.%s: [primary], [deleted], [deletedOn], [version], [createdOn] and [updatedOn] can't be used in a [nested struct].", struc.structName, col.varName)
+		}
+	}
+	return nil
+}
+
+// CheckNestedUsed makes sure every [nested struct] is used by another struct
+func CheckNestedUsed(nested []*structToCreate) error {
+	for _, struc := range nested {
+		if struc.owner == nil {
+			return fmt.Errorf("[nested struct] %s isn't used by any struct. It has to come before the structs using it.", struc.structName)
+		}
+	}
+	return nil
+}
+
+// manyToMany is a join table from a [many to many] line linking two structs
+type manyToMany struct {
+	structNames [2]string
+	tableName   string // "" for the default table1_table2
+	structs     [2]*structToCreate
+	colNames    [2]string // join table columns holding each struct's primary key
 }
 
 type column struct {
-	colName    string
-	varName    string
-	structLine string
-	goType     string
-	dbType     string
-	primary    bool
-	index      bool
-	patch      bool
-	size       string // "" if not varchar w/ size
-	deleted    bool
-	deletedOn  bool
-	nulls      bool
+	colName     string
+	varName     string
+	structLine  string
+	goType      string
+	baseType    string // goType before [nulls] mapping, or the type a pointer variable points to
+	dbType      string
+	primary     bool
+	index       bool
+	unique      bool
+	patch       bool
+	size        string // "" if not varchar w/ size
+	deleted     bool
+	deletedOn   bool
+	createdOn   bool
+	updatedOn   bool
+	nulls       bool
+	nullStyle   string // "nulls", "sql" or "pointer" once a nullable variable's type is mapped
+	upsert      bool
+	version     bool
+	patchGroups []string // [patch:group] names
+	uniqGroups  []string // [unique:group] names, lower case
+	refStruct   string   // struct named by [references], "" if the table isn't generated from this file
+	refTable    string   // table and column named by [references]
+	refColumn   string
+	refStruc    *structToCreate // set by ResolveReferences when refStruct is generated from this file
+	onDelete    string          // ON DELETE action for [references], e.g. "CASCADE"
+	onUpdate    string
+	indexGroups []string // [index:group] names, lower case
+	indexUsing  string   // [using:method], "" for btree
+	indexDesc   bool
+	partial     bool   // [partial] or [partial:predicate] on the column's indexes
+	where       string // predicate from [partial:predicate], "" for the soft-delete filter
+	defaultExpr string // [default:expr]
+	check       string // [check:expr]
+	uuid        bool   // [uuid] or a uuid.UUID variable
+	keyPart     bool   // one of several [primary] columns
+	precision   string // [precision:p,s] for a numeric column
+	timezone    bool   // [timezone] stores a time.Time as timestamp with time zone
+	customDB    string // Postgres type from [dbtype:type]
+	customType  bool   // a [dbtype] variable whose Go type implements sql.Scanner and driver.Valuer
+	enum        *enumType
+	embed       *structToCreate // the embedded [nested struct] the column was flattened from
+	jsonb       bool            // [jsonb] or a [nested struct] variable stored as jsonb
 }
 
 func (struc *structToCreate) CheckStructForDeletes() bool {
@@ -54,8 +258,414 @@ func (struc *structToCreate) CheckStructForDeletes() bool {
 	return true
 }
 
+// VersionCol returns the [version] column used for optimistic locking, or nil
+func (struc *structToCreate) VersionCol() *column {
+	for _, col := range struc.cols {
+		if col.version {
+			return col
+		}
+	}
+	return nil
+}
+
+// CheckVersionCol makes sure there is only one [version] column and that it
+// can only be changed by the generated version checks
+func (struc *structToCreate) CheckVersionCol() error {
+	var versionCnt int
+	for _, col := range struc.cols {
+		if !col.version {
+			continue
+		}
+		versionCnt++
+		switch {
+		case versionCnt > 1:
+			return fmt.Errorf("The [version] keyword can only be used on one column per struct definition.")
+		case col.primary || col.deleted || col.deletedOn:
+			return fmt.Errorf("The [version] column %s can't also be [primary], [deleted] or [deletedOn].", col.varName)
+		case col.nulls:
+			return fmt.Errorf("The [version] column %s can't be [nulls].", col.varName)
+		case col.patch || len(col.patchGroups) > 0:
+			return fmt.Errorf("The [version] column %s can't be patched.", col.varName)
+		}
+	}
+	return nil
+}
+
+// HasTimestampCols reports if the struct has a [createdOn] or [updatedOn] column
+func (struc *structToCreate) HasTimestampCols() bool {
+	for _, col := range struc.cols {
+		if col.createdOn || col.updatedOn {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTimestampCols makes sure [createdOn] and [updatedOn] are each used on one
+// column at most and aren't combined with other keywords that set the column
+func (struc *structToCreate) CheckTimestampCols() error {
+	var createdCnt, updatedCnt int
+	for _, col := range struc.cols {
+		if !col.createdOn && !col.updatedOn {
+			continue
+		}
+		if col.createdOn {
+			createdCnt++
+		}
+		if col.updatedOn {
+			updatedCnt++
+		}
+		switch {
+		case createdCnt > 1 || updatedCnt > 1:
+			return fmt.Errorf("The [createdOn] and [updatedOn] keywords can only be used on one column each per struct definition.")
+		case col.createdOn && col.updatedOn, col.primary, col.version, col.deleted, col.deletedOn:
+			return fmt.Errorf("The column %s can only be marked with one of [primary], [version], [deleted], [deletedOn], [createdOn] and [updatedOn].", col.varName)
+		case col.nulls:
+			return fmt.Errorf("The [createdOn] or [updatedOn] column %s can't be [nulls].", col.varName)
+		case col.patch || len(col.patchGroups) > 0:
+			return fmt.Errorf("The [createdOn] or [updatedOn] column %s can't be patched.", col.varName)
+		}
+	}
+	return nil
+}
+
+// PatchGroups returns the [patch:group] names in the order they first appear
+func (struc *structToCreate) PatchGroups() []string {
+	var groups []string
+	seen := make(map[string]bool)
+	for _, col := range struc.cols {
+		for _, group := range col.patchGroups {
+			if !seen[strings.ToLower(group)] {
+				seen[strings.ToLower(group)] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
+
+// PatchGroupCols returns the columns that belong to a [patch:group]
+func (struc *structToCreate) PatchGroupCols(group string) []*column {
+	var groupCols []*column
+	for _, col := range struc.cols {
+		for _, colGroup := range col.patchGroups {
+			if strings.ToLower(colGroup) == strings.ToLower(group) {
+				groupCols = append(groupCols, col)
+				break
+			}
+		}
+	}
+	return groupCols
+}
+
+// CheckPatchGroups makes sure patch groups don't include the primary key and
+// don't generate the same method as a single column [patch]
+func (struc *structToCreate) CheckPatchGroups() error {
+	for _, group := range struc.PatchGroups() {
+		for _, col := range struc.cols {
+			if col.patch && strings.ToLower(col.varName) == strings.ToLower(group) {
+				return fmt.Errorf("The [patch:%s] group has the same name as the [patch] column %s.", group, col.varName)
+			}
+		}
+		for _, col := range struc.PatchGroupCols(group) {
+			if col.primary {
+				return fmt.Errorf("The [primary] column %s can't be part of the [patch:%s] group.", col.varName, group)
+			}
+		}
+	}
+	return nil
+}
+
+// uniqueKey is a [unique] column or the columns sharing a [unique:group]
+type uniqueKey struct {
+	name string // column or group name used for the uq_table_name constraint
+	cols []*column
+}
+
+// UniqueKeys returns the [unique] columns followed by the [unique:group] keys
+// in the order they first appear
+func (struc *structToCreate) UniqueKeys() []uniqueKey {
+	var keys []uniqueKey
+	for _, col := range struc.cols {
+		if col.unique {
+			keys = append(keys, uniqueKey{name: col.colName, cols: []*column{col}})
+		}
+	}
+	groupIndex := make(map[string]int)
+	for _, col := range struc.cols {
+		for _, group := range col.uniqGroups {
+			i, ok := groupIndex[group]
+			if !ok {
+				i = len(keys)
+				groupIndex[group] = i
+				keys = append(keys, uniqueKey{name: group})
+			}
+			keys[i].cols = append(keys[i].cols, col)
+		}
+	}
+	return keys
+}
+
+// MethodSuffix names the generated lookup for the key, e.g. ByFirstNameAndLastName
+func (key uniqueKey) MethodSuffix() string {
+	return KeyMethodSuffix(key.cols)
+}
+
+// KeyMethodSuffix names a lookup by several columns, e.g. ByFirstNameAndLastName
+func KeyMethodSuffix(cols []*column) string {
+	var varNames []string
+	for _, col := range cols {
+		varNames = append(varNames, UpperCaseFirstChar(col.varName))
+	}
+	return "By" + strings.Join(varNames, "And")
+}
+
+// indexKey is an [index] column or the columns sharing an [index:group]
+type indexKey struct {
+	name  string // column or group name used for the ix_table_name index
+	cols  []*column
+	group bool
+}
+
+// IndexKeys returns the [index] columns followed by the [index:group] keys in
+// the order they first appear
+func (struc *structToCreate) IndexKeys() []indexKey {
+	var keys []indexKey
+	for _, col := range struc.cols {
+		if col.index {
+			keys = append(keys, indexKey{name: col.colName, cols: []*column{col}})
+		}
+	}
+	groupIndex := make(map[string]int)
+	for _, col := range struc.cols {
+		for _, group := range col.indexGroups {
+			i, ok := groupIndex[group]
+			if !ok {
+				i = len(keys)
+				groupIndex[group] = i
+				keys = append(keys, indexKey{name: group, group: true})
+			}
+			keys[i].cols = append(keys[i].cols, col)
+		}
+	}
+	return keys
+}
+
+// Using returns the index method set by the key's columns, btree by default
+func (key indexKey) Using() string {
+	for _, col := range key.cols {
+		if col.indexUsing != "" {
+			return col.indexUsing
+		}
+	}
+	return "btree"
+}
+
+// Where returns the partial index predicate set by the key's columns, "" if the
+// index covers every row. delColName is the struct's [deleted] column.
+func (key indexKey) Where(delColName string) string {
+	for _, col := range key.cols {
+		if col.partial && col.where != "" {
+			return col.where
+		}
+		if col.partial {
+			return delColName + " = false"
+		}
+	}
+	return ""
+}
+
+// CheckIndexKeys makes sure the [using], [desc] and [partial] options are only
+// used on indexed columns and agree within a group
+func (struc *structToCreate) CheckIndexKeys() error {
+	hasDeleted := false
+	for _, col := range struc.cols {
+		if col.deleted {
+			hasDeleted = true
+		}
+	}
+	for _, col := range struc.cols {
+		if (col.indexUsing != "" || col.indexDesc || col.partial) && !col.index && len(col.indexGroups) == 0 {
+			return fmt.Errorf("%s uses [using], [desc] or [partial] but isn't marked [index] or [index:group].", col.varName)
+		}
+		if col.partial && col.where == "" && !hasDeleted {
+			return fmt.Errorf("[partial] on %s needs a [deleted] column, or the predicate can be given with [partial:predicate].", col.varName)
+		}
+	}
+	names := make(map[string]bool)
+	methods := make(map[string]bool)
+	for _, key := range struc.IndexKeys() {
+		using := key.Using()
+		var partialCol *column
+		for _, col := range key.cols {
+			if col.indexUsing != "" && col.indexUsing != using {
+				return fmt.Errorf("The columns of [index:%s] use different index methods.", key.name)
+			}
+			if col.partial && partialCol != nil && col.where != partialCol.where {
+				return fmt.Errorf("The columns of [index:%s] use different [partial] predicates.", key.name)
+			}
+			if col.partial && partialCol == nil {
+				partialCol = col
+			}
+			if col.indexDesc && using != "btree" {
+				return fmt.Errorf("[desc] on %s only works with btree indexes.", col.varName)
+			}
+		}
+		if len(key.cols) > 1 && using == "hash" {
+			return fmt.Errorf("[index:%s] spans several columns, which hash indexes don't support.", key.name)
+		}
+		if names[key.name] {
+			return fmt.Errorf("The [index:%s] group has the same name as the [index] column %s.", key.name, key.name)
+		}
+		if methods[KeyMethodSuffix(key.cols)] {
+			return fmt.Errorf("Two indexes in %s are made of the same columns (%s).", struc.structName, KeyMethodSuffix(key.cols)[2:])
+		}
+		names[key.name] = true
+		methods[KeyMethodSuffix(key.cols)] = true
+	}
+	return nil
+}
+
+// CheckUniqueKeys makes sure the primary key isn't marked unique again and that
+// no two keys share a constraint or method name
+func (struc *structToCreate) CheckUniqueKeys() error {
+	names := make(map[string]bool)
+	methods := make(map[string]bool)
+	for _, key := range struc.UniqueKeys() {
+		for _, col := range key.cols {
+			if col.primary {
+				return fmt.Errorf("The [primary] column %s is already unique and can't be marked [unique].", col.varName)
+			}
+		}
+		if names[key.name] {
+			return fmt.Errorf("The [unique:%s] group has the same name as the [unique] column %s.", key.name, key.name)
+		}
+		if methods[key.MethodSuffix()] {
+			return fmt.Errorf("Two unique keys in %s are made of the same columns (%s).", struc.structName, key.MethodSuffix()[2:])
+		}
+		names[key.name] = true
+		methods[key.MethodSuffix()] = true
+	}
+	return nil
+}
+
+// ResolveUpsertCols marks the columns named by [upsert:...] as the ON CONFLICT
+// target of the generated Upsert(). Names can be struct variables or column names.
+func (struc *structToCreate) ResolveUpsertCols() error {
+	for _, name := range struc.upsertVars {
+		found := false
+		for _, col := range struc.cols {
+			if strings.ToLower(col.varName) == name || col.colName == name {
+				col.upsert = true
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("The [upsert] column %s doesn't match a struct variable.", name)
+		}
+	}
+	return nil
+}
+
+// UpsertConflictCols returns the ON CONFLICT target columns, which default to
+// the primary key when [upsert] doesn't list any
+func (struc *structToCreate) UpsertConflictCols() []*column {
+	var conflictCols []*column
+	var primCols []*column
+	for _, col := range struc.cols {
+		if col.upsert {
+			conflictCols = append(conflictCols, col)
+		}
+		if col.primary {
+			primCols = append(primCols, col)
+		}
+	}
+	if len(conflictCols) == 0 {
+		return primCols
+	}
+	return conflictCols
+}
+
+// DBManaged reports if the column's value is set by the DB ([version], [createdOn]
+// and [updatedOn]), so it is left out of INSERTs and UPDATEs and read back instead
+func (col *column) DBManaged() bool {
+	return col.version || col.createdOn || col.updatedOn
+}
+
+// InMethodParams reports if the column's Go type is written in the parameters
+// of the generated Get, Count and Patch methods
+func (col *column) InMethodParams() bool {
+	return col.index || col.unique || col.patch || len(col.indexGroups) > 0 || len(col.uniqGroups) > 0 || len(col.patchGroups) > 0
+}
+
+// JSONKey returns the key encoding/json uses for the column's struct variable,
+// or "" if the json tag is "-"
+func (col *column) JSONKey() string {
+	tagParts := strings.Split(col.structLine, "`")
+	if len(tagParts) < 2 {
+		return col.varName
+	}
+	tag, ok := reflect.StructTag(tagParts[1]).Lookup("json")
+	if !ok {
+		return col.varName
+	}
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.TrimSpace(strings.Split(tag, ",")[0]); name != "" {
+		return name
+	}
+	return col.varName
+}
+
+// ChangedExpr returns a Go expression that is true when the column's value
+// differs between the struct values a and b (e.g. "user" and "user.snapshot")
+func (col *column) ChangedExpr(a string, b string) string {
+	a = a + "." + col.varName
+	b = b + "." + col.varName
+	//custom types may not be comparable with !=
+	if col.customType {
+		return fmt.Sprintf("!reflect.DeepEqual(%s, %s)", a, b)
+	}
+	switch col.nullStyle {
+	case "pointer":
+		//both nil or both pointing to equal values, Equal is called through the pointer
+		notEqual := notEqualExpr(col.baseType, "*"+a, "*"+b)
+		if col.baseType == "time.Time" || col.baseType == "decimal.Decimal" {
+			notEqual = notEqualExpr(col.baseType, a, "*"+b)
+		}
+		return fmt.Sprintf("(%s == nil) != (%s == nil) || (%s != nil && %s)", a, b, a, notEqual)
+	case "nulls", "sql":
+		if col.baseType == "[]byte" || col.baseType == "time.Time" {
+			field := col.NullValueField()
+			return fmt.Sprintf("%s.Valid != %s.Valid || %s", a, b, notEqualExpr(col.baseType, a+"."+field, b+"."+field))
+		}
+		return a + " != " + b
+	}
+	switch col.goType {
+	case "[]byte", "time.Time", "net.IP", "decimal.Decimal":
+		return notEqualExpr(col.goType, a, b)
+	}
+	if kind := col.ValueKind(); kind == "array" || kind == "jsonb" {
+		return fmt.Sprintf("!reflect.DeepEqual(%s, %s)", a, b)
+	}
+	return a + " != " + b
+}
+
+// notEqualExpr compares a and b of a type that isn't comparable with !=, or
+// whose == doesn't match equal values
+func notEqualExpr(goType string, a string, b string) string {
+	switch goType {
+	case "[]byte":
+		return fmt.Sprintf("!bytes.Equal(%s, %s)", a, b)
+	case "time.Time", "net.IP", "decimal.Decimal":
+		return fmt.Sprintf("!%s.Equal(%s)", a, b)
+	}
+	return a + " != " + b
+}
+
 func (col *column) MapGoTypeToDBTypes() (bool, string) {
-	switch strings.ToLower(col.goType) {
+	switch strings.ToLower(col.ArgType()) {
 	case "int", "int8", "int16", "int32", "uint", "uint8", "uint16", "uint32", "uintptr", "byte":
 		col.dbType = "integer"
 	case "int64", "uint64":
@@ -78,14 +688,160 @@ func (col *column) MapGoTypeToDBTypes() (bool, string) {
 		col.dbType = "character varying"
 	case "[]byte":
 		col.dbType = "bytea"
+	case "uuid.uuid":
+		col.dbType = "uuid"
+	case "[]string":
+		col.dbType = "text[]"
+	case "[]int64":
+		col.dbType = "bigint[]"
+	case "[]int32":
+		col.dbType = "integer[]"
+	case "[]float64":
+		col.dbType = "double precision[]"
+	case "[]float32":
+		col.dbType = "real[]"
+	case "[]bool":
+		col.dbType = "boolean[]"
+	case "json.rawmessage":
+		col.dbType = "jsonb"
+	case "time.duration":
+		col.dbType = "interval"
+	case "net.ip":
+		col.dbType = "inet"
+	case "decimal.decimal":
+		col.dbType = "numeric"
 
 	default:
+		if strings.HasPrefix(col.ArgType(), "map[") {
+			col.dbType = "jsonb"
+			break
+		}
 		return false, "A non-supported data type (" + col.goType + ") was provided. The [ignore] option can be added to the end of a struct variable allowing it to be ignored for code generation."
 	}
 	return true, ""
 }
 
-func (col *column) MapNullTypes() error {
+// ValueKind groups the Go types that database/sql can't pass as they are:
+// "array" for slices passed through pq.Array, "jsonb" for json.RawMessage and
+// maps, "interval" for time.Duration and "inet" for net.IP. Other types,
+// including [dbtype] custom types, return "".
+func (col *column) ValueKind() string {
+	if col.customType {
+		return ""
+	}
+	if col.jsonb {
+		return "jsonb"
+	}
+	switch goType := strings.ToLower(col.goType); {
+	case goType == "json.rawmessage" || strings.HasPrefix(goType, "map["):
+		return "jsonb"
+	case strings.HasPrefix(goType, "[]") && goType != "[]byte":
+		return "array"
+	case goType == "time.duration":
+		return "interval"
+	case goType == "net.ip":
+		return "inet"
+	}
+	return ""
+}
+
+// ApplyDBType replaces the mapped DB type with the one from [dbtype:type]
+func (col *column) ApplyDBType() error {
+	if col.customDB == "" {
+		return nil
+	}
+	if col.precision != "" || col.timezone || col.uuid {
+		return fmt.Errorf("[dbtype] can't be combined with [precision], [timezone] or [uuid] (%s).", col.varName)
+	}
+	if strings.ContainsAny(col.customDB, "\"\\;") {
+		return fmt.Errorf("[dbtype] on %s can't contain double quotes, backslashes or semicolons.", col.varName)
+	}
+	col.dbType = col.customDB
+	return nil
+}
+
+// ApplyTypeOptions changes the column's DB type for [precision:p,s] and
+// [timezone] once its Go type has been mapped
+func (col *column) ApplyTypeOptions() error {
+	if col.precision != "" {
+		switch strings.ToLower(col.ArgType()) {
+		case "float32", "float64", "string", "decimal.decimal":
+		default:
+			return fmt.Errorf("[precision] can only be used with float32, float64, string or decimal.Decimal (%s).", col.varName)
+		}
+		parts := strings.Split(col.precision, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+			if _, err := strconv.Atoi(parts[i]); err != nil || len(parts) > 2 {
+				return fmt.Errorf("[precision:%s] on %s must be a precision and an optional scale, e.g. [precision:12,2].", col.precision, col.varName)
+			}
+		}
+		col.dbType = "numeric(" + strings.Join(parts, ",") + ")"
+	}
+	if col.timezone {
+		if strings.ToLower(col.ArgType()) != "time.time" {
+			return fmt.Errorf("[timezone] can only be used with time.Time (%s).", col.varName)
+		}
+		col.dbType = "timestamp with time zone"
+	}
+	return nil
+}
+
+// SelectExpr returns the SELECT list entry for the column. Intervals are read
+// as nanoseconds so they scan straight into a time.Duration.
+func (col *column) SelectExpr() string {
+	if col.ValueKind() == "interval" {
+		return fmt.Sprintf("(EXTRACT(EPOCH FROM %s) * 1000000000)::bigint", col.colName)
+	}
+	return col.colName
+}
+
+// ParseNullsStyle checks the value of [nulls style]: nulls (the default) for
+// github.com/markbates/going/nulls, sql for the database/sql null types or
+// pointer for *T variables
+func ParseNullsStyle(style string) (string, error) {
+	switch strings.ToLower(style) {
+	case "", "nulls":
+		return "nulls", nil
+	case "sql":
+		return "sql", nil
+	case "pointer", "pointers":
+		return "pointer", nil
+	}
+	return "", fmt.Errorf("[nulls style] must be nulls, sql or pointer (%s).", style)
+}
+
+// sqlNullTypes maps Go types to the database/sql types with their own Null
+// type, other types use sql.Null[T]
+var sqlNullTypes = map[string]string{
+	"string":    "sql.NullString",
+	"int64":     "sql.NullInt64",
+	"int32":     "sql.NullInt32",
+	"int16":     "sql.NullInt16",
+	"byte":      "sql.NullByte",
+	"uint8":     "sql.NullByte",
+	"float64":   "sql.NullFloat64",
+	"bool":      "sql.NullBool",
+	"time.Time": "sql.NullTime",
+}
+
+// MapNullTypes changes the Go type of a [nulls] variable to one that can hold
+// NULL in the [nulls style] of the file
+func (col *column) MapNullTypes(style string) error {
+	col.baseType = col.goType
+	col.nullStyle = style
+	switch style {
+	case "pointer":
+		col.goType = "*" + col.goType
+		return nil
+	case "sql":
+		if sqlType, found := sqlNullTypes[col.goType]; found {
+			col.goType = sqlType
+		} else {
+			col.goType = "sql.Null[" + col.goType + "]"
+		}
+		return nil
+	}
 	switch strings.ToLower(col.goType) {
 	case "int":
 		col.goType = "nulls.Int"
@@ -108,7 +864,30 @@ func (col *column) MapNullTypes() error {
 	case "[]byte":
 		col.goType = "nulls.ByteSlice"
 	default:
-		return fmt.Errorf("A non-supported data type (" + col.goType + ") was provided as a nullable column. Types must be int64, uint32, int32, int, float64, float32,  string, bool, time.Time, or []byte.")
+		return fmt.Errorf("A non-supported data type (%s) was provided as a nullable column. Types must be int64, uint32, int32, int, float64, float32, string, bool, time.Time, or []byte. Other types can be nullable with [nulls style] sql or pointer.", col.goType)
+	}
+	return nil
+}
+
+// QueryPredicates returns the method suffix and SQL operator pairs the generated
+// query builder offers for the column, based on its Go type
+func (col *column) QueryPredicates() [][]string {
+	goType := col.goType
+	if col.baseType != "" {
+		goType = col.baseType
+	}
+	if col.uuid || col.enum != nil {
+		return [][]string{{"Eq", "="}, {"NotEq", "<>"}}
+	}
+	switch strings.ToLower(goType) {
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr", "byte", "float32", "float64":
+		return [][]string{{"Eq", "="}, {"NotEq", "<>"}, {"Gt", ">"}, {"Gte", ">="}, {"Lt", "<"}, {"Lte", "<="}}
+	case "time.time":
+		return [][]string{{"Eq", "="}, {"NotEq", "<>"}, {"After", ">"}, {"Before", "<"}}
+	case "string", "rune":
+		return [][]string{{"Eq", "="}, {"NotEq", "<>"}, {"Like", "LIKE"}, {"ILike", "ILIKE"}}
+	case "bool":
+		return [][]string{{"Eq", "="}, {"NotEq", "<>"}}
 	}
 	return nil
 }
@@ -128,3 +907,386 @@ func CheckColAndTblNames(name string) error {
 	}
 	return nil
 }
+
+// ParseReferences fills the referenced struct or table.column of a [references:...] option,
+// table and column names are lower cased like the ones StreetCRUD creates
+func (col *column) ParseReferences(ref string) error {
+	ref = strings.TrimSpace(ref)
+	if dot := strings.Index(ref, "."); dot >= 0 {
+		col.refTable = strings.ToLower(strings.TrimSpace(ref[:dot]))
+		col.refColumn = strings.ToLower(strings.TrimSpace(ref[dot+1:]))
+		if err := CheckColAndTblNames(col.refTable); err != nil {
+			return err
+		}
+		return CheckColAndTblNames(col.refColumn)
+	}
+	col.refStruct = ref
+	return CheckColAndTblNames(ref)
+}
+
+// ParseReferentialAction checks the value of an [ondelete:...] or [onupdate:...] option
+// and returns it in the form used by SQL
+func ParseReferentialAction(action string) (string, error) {
+	action = strings.ToUpper(TrimInnerSpacesToOne(strings.TrimSpace(action)))
+	switch action {
+	case "CASCADE", "RESTRICT", "SET NULL", "SET DEFAULT", "NO ACTION":
+		return action, nil
+	}
+	return "", fmt.Errorf("%s is not a valid [ondelete] or [onupdate] action. Use cascade, restrict, set null, set default or no action.", strings.ToLower(action))
+}
+
+// ResolveReferences points [references:Struct] columns at the struct's table and
+// primary key, and links [references:table.column] columns to the struct generating
+// that table if there is one
+func ResolveReferences(structs []*structToCreate) error {
+	for _, struc := range structs {
+		for _, col := range struc.cols {
+			if col.refStruct == "" && col.refTable == "" {
+				if col.onDelete != "" || col.onUpdate != "" {
+					return fmt.Errorf("[ondelete] and [onupdate] can only be used with [references] (%s.%s).", struc.structName, col.varName)
+				}
+				continue
+			}
+			if col.onDelete == "SET NULL" && !col.nulls {
+				return fmt.Errorf("%s.%s must be marked [nulls] to use [ondelete:set null].", struc.structName, col.varName)
+			}
+			if col.refStruct != "" {
+				var refStruc *structToCreate
+				for _, other := range structs {
+					if strings.ToLower(other.structName) == strings.ToLower(col.refStruct) {
+						refStruc = other
+					}
+				}
+				if refStruc == nil {
+					return fmt.Errorf("%s.%s references the struct %s, which isn't defined in the file. Use [references:table.column] for tables generated elsewhere.", struc.structName, col.varName, col.refStruct)
+				}
+				col.refStruct = refStruc.structName
+				col.refStruc = refStruc
+				col.refTable = refStruc.tableName
+				if len(refStruc.PrimaryCols()) > 1 {
+					return fmt.Errorf("%s.%s references %s, which has a composite primary key. Use [references:table.column] to point at one of its columns.", struc.structName, col.varName, refStruc.structName)
+				}
+				for _, refCol := range refStruc.cols {
+					if refCol.primary {
+						col.refColumn = refCol.colName
+					}
+				}
+				continue
+			}
+			for _, other := range structs {
+				if other.tableName == col.refTable && other.schema == struc.schema {
+					col.refStruct = other.structName
+					col.refStruc = other
+				}
+			}
+			if col.refStruc != nil && col.RefCol() == nil {
+				return fmt.Errorf("%s.%s references %s.%s, but %s has no column named %s.", struc.structName, col.varName, col.refTable, col.refColumn, col.refStruct, col.refColumn)
+			}
+		}
+	}
+	return nil
+}
+
+// RefCol returns the referenced column when [references] points at a struct
+// generated from the same file
+func (col *column) RefCol() *column {
+	if col.refStruc == nil {
+		return nil
+	}
+	for _, refCol := range col.refStruc.cols {
+		if refCol.colName == col.refColumn {
+			return refCol
+		}
+	}
+	return nil
+}
+
+// RefName names the loader methods of a [references] column after the variable
+// without its ID suffix (AuthorID becomes Author). Variables without the suffix
+// get the referenced struct's name added so the method doesn't clash with the variable.
+func (col *column) RefName() string {
+	for _, suffix := range []string{"ID", "Id"} {
+		if name := strings.TrimSuffix(col.varName, suffix); name != col.varName && name != "" {
+			return UpperCaseFirstChar(name)
+		}
+	}
+	return UpperCaseFirstChar(col.varName) + col.refStruct
+}
+
+// ArgType returns the plain Go type used for the column's query parameters,
+// which is the type before [nulls] mapping
+func (col *column) ArgType() string {
+	if col.baseType != "" {
+		return col.baseType
+	}
+	return col.goType
+}
+
+// ValueExpr returns the Go expression for the column's plain value on the struct
+// value obj, e.g. "blog.UserID" or "blog.UserID.Int" for a [nulls] column
+func (col *column) ValueExpr(obj string) string {
+	switch col.nullStyle {
+	case "pointer":
+		return "*" + obj + "." + col.varName
+	case "nulls", "sql":
+		return obj + "." + col.varName + "." + col.NullValueField()
+	}
+	return obj + "." + col.varName
+}
+
+// NullValueField returns the field of a nullable type holding its value, e.g.
+// Int for nulls.Int, String for sql.NullString and V for sql.Null[T]
+func (col *column) NullValueField() string {
+	switch {
+	case strings.HasPrefix(col.goType, "nulls."):
+		return strings.TrimPrefix(col.goType, "nulls.")
+	case strings.HasPrefix(col.goType, "sql.Null["):
+		return "V"
+	case strings.HasPrefix(col.goType, "sql.Null"):
+		return strings.TrimPrefix(col.goType, "sql.Null")
+	}
+	return ""
+}
+
+// IsNullExpr returns the Go expression that is true when the nullable column is
+// NULL on the struct value obj, e.g. "!blog.UserID.Valid" or "blog.UserID == nil"
+func (col *column) IsNullExpr(obj string) string {
+	if col.nullStyle == "pointer" {
+		return obj + "." + col.varName + " == nil"
+	}
+	return "!" + obj + "." + col.varName + ".Valid"
+}
+
+// NullZero returns the Go literal of a NULL value of the column's type
+func (col *column) NullZero() string {
+	if col.nullStyle == "pointer" {
+		return "nil"
+	}
+	return col.goType + "{}"
+}
+
+// SortStructsByReferences orders structs so the ones that are referenced come
+// before the ones referencing them. Structs keep their file order otherwise, and
+// cycles are broken at the struct that was reached first.
+func SortStructsByReferences(structs []*structToCreate) []*structToCreate {
+	var sorted []*structToCreate
+	visited := make(map[*structToCreate]bool)
+	var visit func(struc *structToCreate)
+	visit = func(struc *structToCreate) {
+		if visited[struc] {
+			return
+		}
+		visited[struc] = true
+		for _, col := range struc.cols {
+			if col.refStruct == "" {
+				continue
+			}
+			for _, other := range structs {
+				if other.structName == col.refStruct {
+					visit(other)
+				}
+			}
+		}
+		sorted = append(sorted, struc)
+	}
+	for _, struc := range structs {
+		visit(struc)
+	}
+	return sorted
+}
+
+// ResolveJoins links each [many to many] line to its two structs and names the
+// join table and its columns
+func ResolveJoins(structs []*structToCreate, joins []*manyToMany) error {
+	for _, join := range joins {
+		for i, name := range join.structNames {
+			for _, struc := range structs {
+				if strings.ToLower(struc.structName) == strings.ToLower(name) {
+					join.structs[i] = struc
+				}
+			}
+			if join.structs[i] == nil {
+				return fmt.Errorf("[many to many] %s %s: the struct %s isn't defined in the file.", join.structNames[0], join.structNames[1], name)
+			}
+		}
+		if join.structs[0] == join.structs[1] {
+			return fmt.Errorf("[many to many] %s %s: a struct can't be joined to itself.", join.structNames[0], join.structNames[1])
+		}
+		//a single [references] column already gives the referenced struct an Objects() loader
+		for i, struc := range join.structs {
+			other := join.structs[1-i]
+			refCnt := 0
+			for _, col := range other.cols {
+				if col.refStruc == struc {
+					refCnt++
+				}
+			}
+			if refCnt == 1 {
+				return fmt.Errorf("[many to many] %s %s: %s already has a %ss() method from the [references] column in %s.", join.structNames[0], join.structNames[1], struc.structName, other.structName, other.structName)
+			}
+		}
+		if join.tableName == "" {
+			join.tableName = join.structs[0].tableName + "_" + join.structs[1].tableName
+		}
+		for i, struc := range join.structs {
+			if len(struc.PrimaryCols()) > 1 {
+				return fmt.Errorf("[many to many] %s %s: %s has a composite primary key, use a struct with [references] columns instead.", join.structNames[0], join.structNames[1], struc.structName)
+			}
+			join.colNames[i] = struc.PrimaryCol().colName
+		}
+		//both primary keys named id become user_id and role_id
+		if join.colNames[0] == join.colNames[1] {
+			for i, struc := range join.structs {
+				join.colNames[i] = struc.tableName + "_" + join.colNames[i]
+			}
+		}
+		for _, struc := range join.structs {
+			struc.joins = append(struc.joins, join)
+		}
+	}
+	return nil
+}
+
+// PrimaryCol returns the [primary] column, the first one for a composite key
+func (struc *structToCreate) PrimaryCol() *column {
+	for _, col := range struc.cols {
+		if col.primary {
+			return col
+		}
+	}
+	return nil
+}
+
+// PrimaryCols returns the [primary] columns in the order they're declared
+func (struc *structToCreate) PrimaryCols() []*column {
+	var primCols []*column
+	for _, col := range struc.cols {
+		if col.primary {
+			primCols = append(primCols, col)
+		}
+	}
+	return primCols
+}
+
+// CheckPrimaryKeys marks the columns of a composite primary key. Key parts are
+// always supplied by the caller, none of them is sequenced or generated.
+func (struc *structToCreate) CheckPrimaryKeys() error {
+	primCols := struc.PrimaryCols()
+	for _, col := range primCols {
+		if col.customType {
+			return fmt.Errorf("%s.%s is a [dbtype] custom type and can't be part of the primary key.", struc.structName, col.varName)
+		}
+	}
+	if struc.identity && (len(primCols) != 1 || !primCols[0].Sequenced()) {
+		return fmt.Errorf("[identity] can only be used on a struct with a single integer primary key (%s).", struc.structName)
+	}
+	if len(primCols) < 2 {
+		return nil
+	}
+	for _, col := range primCols {
+		if col.nulls {
+			return fmt.Errorf("%s.%s is part of the primary key and can't be marked [nulls].", struc.structName, col.varName)
+		}
+		col.keyPart = true
+	}
+	return nil
+}
+
+// NextvalExpr returns the SQL that takes the next value for the integer primary
+// key, from the column's identity or from the sequence StreetCRUD created
+func (struc *structToCreate) NextvalExpr(col *column) string {
+	if struc.identity {
+		return fmt.Sprintf("nextval(pg_get_serial_sequence('%s.%s', '%s'))", AddQuotesIfAnyUpperCase(struc.schema), struc.tableName, col.colName)
+	}
+	return fmt.Sprintf("nextval('%s.%s_%s_seq'::regclass)", AddQuotesIfAnyUpperCase(struc.schema), struc.tableName, col.colName)
+}
+
+// CheckDefaults makes sure [default] isn't used on columns whose values the
+// primary key sequence or StreetCRUD already provide
+func (struc *structToCreate) CheckDefaults() error {
+	for _, col := range struc.cols {
+		if col.defaultExpr == "" {
+			continue
+		}
+		if col.primary || col.deleted || col.DBManaged() {
+			return fmt.Errorf("%s already has a default and can't use [default].", col.varName)
+		}
+		if col.ValueKind() != "" || col.customType {
+			return fmt.Errorf("[default] can't be used on %s, a %s column.", col.varName, col.dbType)
+		}
+		if strings.ContainsAny(col.defaultExpr, "\"\\") {
+			return fmt.Errorf("[default] on %s can't contain double quotes or backslashes.", col.varName)
+		}
+	}
+	return nil
+}
+
+// ZeroLiteral returns the SQL literal for the Go zero value of the column
+func (col *column) ZeroLiteral() string {
+	switch strings.ToLower(col.goType) {
+	case "string", "rune":
+		return "''"
+	case "bool":
+		return "false"
+	case "time.time":
+		return "'0001-01-01 00:00:00'"
+	case "[]byte":
+		return "''::bytea"
+	case "uuid.uuid":
+		return "'00000000-0000-0000-0000-000000000000'"
+	}
+	return "0"
+}
+
+// ZeroValue returns the Go zero value of a primary key column
+func (col *column) ZeroValue() string {
+	switch strings.ToLower(col.goType) {
+	case "string":
+		return `""`
+	case "uuid.uuid":
+		return "uuid.Nil"
+	}
+	return "0"
+}
+
+// Sequenced reports if the column is an integer primary key filled from the
+// table's sequence, as opposed to a [uuid], natural or composite key
+func (col *column) Sequenced() bool {
+	return col.primary && !col.keyPart && !col.uuid && strings.ToLower(col.goType) != "string"
+}
+
+// GeneratedKey reports if the column is a [uuid] primary key that takes
+// gen_random_uuid() when it's left zero
+func (col *column) GeneratedKey() bool {
+	return col.primary && col.uuid && !col.keyPart
+}
+
+// KeyInsertValue returns the VALUES expression for a primary key that isn't
+// sequenced. A zero [uuid] key takes gen_random_uuid() unless it's part of a
+// composite key.
+func (col *column) KeyInsertValue(param string) string {
+	if !col.GeneratedKey() {
+		return param
+	}
+	if strings.ToLower(col.goType) == "string" {
+		return fmt.Sprintf("COALESCE(NULLIF(%s::text, '')::uuid, gen_random_uuid())", param)
+	}
+	return fmt.Sprintf("COALESCE(NULLIF(%s::uuid, %s), gen_random_uuid())", param, col.ZeroLiteral())
+}
+
+// InsertValue returns the VALUES expression for the column's parameter. A
+// [default] column swaps a zero value (or NULL) for its default expression.
+func (col *column) InsertValue(param string, insertZeros bool) string {
+	if col.defaultExpr == "" || insertZeros {
+		return param
+	}
+	//an empty enum is sent as NULL by its Value method
+	if col.nulls || col.enum != nil {
+		return fmt.Sprintf("COALESCE(%s::%s, %s)", param, col.dbType, col.defaultExpr)
+	}
+	if col.uuid && strings.ToLower(col.goType) == "string" {
+		//an empty string isn't a valid uuid, compare it as text first
+		return fmt.Sprintf("COALESCE(NULLIF(%s::text, '')::uuid, %s)", param, col.defaultExpr)
+	}
+	return fmt.Sprintf("COALESCE(NULLIF(%s::%s, %s), %s)", param, col.dbType, col.ZeroLiteral(), col.defaultExpr)
+}
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestCheckStructForDeletes(t *testing.T) {
 	tests := []struct {
@@ -23,3 +26,605 @@ func TestCheckStructForDeletes(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryPredicates(t *testing.T) {
+	tests := []struct {
+		col  column
+		want []string
+	}{
+		{column{goType: "int64"}, []string{"Eq", "NotEq", "Gt", "Gte", "Lt", "Lte"}},
+		{column{goType: "time.Time"}, []string{"Eq", "NotEq", "After", "Before"}},
+		{column{goType: "nulls.String", baseType: "string"}, []string{"Eq", "NotEq", "Like", "ILike"}},
+		{column{goType: "bool"}, []string{"Eq", "NotEq"}},
+		{column{goType: "[]byte"}, nil},
+	}
+	for _, tt := range tests {
+		got := tt.col.QueryPredicates()
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d predicates, want %d", tt.col.goType, len(got), len(tt.want))
+			continue
+		}
+		for i, predicate := range got {
+			if predicate[0] != tt.want[i] {
+				t.Errorf("%s: predicate %d = %q, want %q", tt.col.goType, i, predicate[0], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestUpsertConflictCols(t *testing.T) {
+	prim := &column{varName: "LoginID", colName: "login_id", primary: true}
+	email := &column{varName: "Email", colName: "email"}
+	s := &structToCreate{cols: []*column{prim, email}}
+	if got := s.UpsertConflictCols(); len(got) != 1 || got[0] != prim {
+		t.Errorf("without [upsert] columns: got %v, want the primary key", got)
+	}
+	s.upsertVars = []string{"email"}
+	if err := s.ResolveUpsertCols(); err != nil {
+		t.Fatalf("ResolveUpsertCols returned error: %v", err)
+	}
+	if got := s.UpsertConflictCols(); len(got) != 1 || got[0] != email {
+		t.Errorf("with [upsert:email]: got %v, want the email column", got)
+	}
+	s.upsertVars = []string{"phone"}
+	if err := s.ResolveUpsertCols(); err == nil {
+		t.Errorf("expected an error for an unknown [upsert] column")
+	}
+}
+
+func TestCheckPatchGroups(t *testing.T) {
+	prim := &column{varName: "LoginID", primary: true}
+	name := &column{varName: "Name", patchGroups: []string{"profile"}}
+	email := &column{varName: "Email", patchGroups: []string{"Profile", "contact"}}
+	s := &structToCreate{cols: []*column{prim, name, email}}
+	if got := s.PatchGroups(); len(got) != 2 || got[0] != "profile" || got[1] != "contact" {
+		t.Errorf("PatchGroups() = %v, want [profile contact]", got)
+	}
+	if got := s.PatchGroupCols("profile"); len(got) != 2 {
+		t.Errorf("PatchGroupCols(profile) returned %d columns, want 2", len(got))
+	}
+	if err := s.CheckPatchGroups(); err != nil {
+		t.Errorf("CheckPatchGroups returned error: %v", err)
+	}
+	email.patch = true
+	email.varName = "Contact"
+	if err := s.CheckPatchGroups(); err == nil {
+		t.Errorf("expected an error when a group and a [patch] column share a name")
+	}
+	email.patch = false
+	prim.patchGroups = []string{"profile"}
+	if err := s.CheckPatchGroups(); err == nil {
+		t.Errorf("expected an error when the primary key is in a group")
+	}
+}
+
+func TestChangedExpr(t *testing.T) {
+	tests := []struct {
+		goType    string
+		nullStyle string
+		want      string
+	}{
+		{"string", "", "u.Name != s.Name"},
+		{"time.Time", "", "!u.Name.Equal(s.Name)"},
+		{"[]byte", "", "!bytes.Equal(u.Name, s.Name)"},
+		{"time.Time", "nulls", "u.Name.Valid != s.Name.Valid || !u.Name.Time.Equal(s.Name.Time)"},
+		{"string", "sql", "u.Name != s.Name"},
+		{"[]byte", "sql", "u.Name.Valid != s.Name.Valid || !bytes.Equal(u.Name.V, s.Name.V)"},
+		{"string", "pointer", "(u.Name == nil) != (s.Name == nil) || (u.Name != nil && *u.Name != *s.Name)"},
+		{"time.Time", "pointer", "(u.Name == nil) != (s.Name == nil) || (u.Name != nil && !u.Name.Equal(*s.Name))"},
+	}
+	for _, tt := range tests {
+		col := &column{varName: "Name", goType: tt.goType}
+		if tt.nullStyle != "" {
+			if err := col.MapNullTypes(tt.nullStyle); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := col.ChangedExpr("u", "s"); got != tt.want {
+			t.Errorf("%s %s: ChangedExpr = %q, want %q", tt.nullStyle, tt.goType, got, tt.want)
+		}
+	}
+}
+
+func TestJSONKey(t *testing.T) {
+	tests := []struct {
+		varName    string
+		structLine string
+		want       string
+	}{
+		{"Name", "Name string `json:\"name,omitempty\"`", "name"},
+		{"Name", "Name string `json:\",omitempty\"`", "Name"},
+		{"Name", "Name string", "Name"},
+		{"Name", "Name string `db:\"name\"`", "Name"},
+		{"Password", "Password string `json:\"-\"`", ""},
+		{"Dash", "Dash string `json:\"-,\"`", "-"},
+	}
+	for _, tt := range tests {
+		col := &column{varName: tt.varName, structLine: tt.structLine}
+		if got := col.JSONKey(); got != tt.want {
+			t.Errorf("JSONKey(%q) = %q, want %q", tt.structLine, got, tt.want)
+		}
+	}
+}
+
+func TestCheckVersionCol(t *testing.T) {
+	tests := []struct {
+		name    string
+		cols    []*column
+		wantErr bool
+	}{
+		{"no version", []*column{{varName: "ID", primary: true}}, false},
+		{"one version", []*column{{varName: "ID", primary: true}, {varName: "Version", version: true}}, false},
+		{"two versions", []*column{{varName: "V1", version: true}, {varName: "V2", version: true}}, true},
+		{"primary version", []*column{{varName: "ID", primary: true, version: true}}, true},
+		{"nulls version", []*column{{varName: "Version", version: true, nulls: true}}, true},
+		{"patched version", []*column{{varName: "Version", version: true, patch: true}}, true},
+		{"grouped version", []*column{{varName: "Version", version: true, patchGroups: []string{"info"}}}, true},
+	}
+	for _, tt := range tests {
+		struc := &structToCreate{cols: tt.cols}
+		if err := struc.CheckVersionCol(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: CheckVersionCol() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckTimestampCols(t *testing.T) {
+	tests := []struct {
+		name    string
+		cols    []*column
+		wantErr bool
+	}{
+		{"none", []*column{{varName: "ID", primary: true}}, false},
+		{"created and updated", []*column{{varName: "Created", createdOn: true}, {varName: "Updated", updatedOn: true}}, false},
+		{"two created", []*column{{varName: "Created", createdOn: true}, {varName: "Made", createdOn: true}}, true},
+		{"created and updated on one column", []*column{{varName: "Stamp", createdOn: true, updatedOn: true}}, true},
+		{"deletedOn and updated", []*column{{varName: "Stamp", deletedOn: true, updatedOn: true}}, true},
+		{"nulls", []*column{{varName: "Updated", updatedOn: true, nulls: true}}, true},
+		{"patched", []*column{{varName: "Updated", updatedOn: true, patch: true}}, true},
+	}
+	for _, tt := range tests {
+		struc := &structToCreate{cols: tt.cols}
+		if err := struc.CheckTimestampCols(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: CheckTimestampCols() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseReferentialAction(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"cascade", "CASCADE", false},
+		{" set  null", "SET NULL", false},
+		{"no action", "NO ACTION", false},
+		{"delete", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReferentialAction(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseReferentialAction(%q) = %q, %v, want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveReferences(t *testing.T) {
+	user := &structToCreate{structName: "User", tableName: "users", schema: "public", cols: []*column{{colName: "login_id", primary: true}}}
+	byStruct := &column{varName: "UserID", refStruct: "user", onDelete: "CASCADE"}
+	byTable := &column{varName: "Owner", refTable: "users", refColumn: "login_id"}
+	external := &column{varName: "CatID", refTable: "category", refColumn: "id"}
+	blog := &structToCreate{structName: "Blog", tableName: "blog", schema: "public", cols: []*column{byStruct, byTable, external}}
+	if err := ResolveReferences([]*structToCreate{user, blog}); err != nil {
+		t.Fatalf("ResolveReferences() returned error: %v", err)
+	}
+	if byStruct.refStruct != "User" || byStruct.refTable != "users" || byStruct.refColumn != "login_id" {
+		t.Errorf("[references:user] resolved to %s %s.%s", byStruct.refStruct, byStruct.refTable, byStruct.refColumn)
+	}
+	if byTable.refStruct != "User" {
+		t.Errorf("[references:users.login_id] resolved to struct %q, want User", byTable.refStruct)
+	}
+	if external.refStruct != "" {
+		t.Errorf("[references:category.id] resolved to struct %q, want none", external.refStruct)
+	}
+
+	errTests := []struct {
+		name string
+		col  *column
+	}{
+		{"unknown struct", &column{varName: "PostID", refStruct: "Post"}},
+		{"set null without nulls", &column{varName: "UserID", refStruct: "User", onDelete: "SET NULL"}},
+		{"action without references", &column{varName: "UserID", onUpdate: "CASCADE"}},
+	}
+	for _, tt := range errTests {
+		other := &structToCreate{structName: "Other", cols: []*column{tt.col}}
+		if err := ResolveReferences([]*structToCreate{user, other}); err == nil {
+			t.Errorf("%s: ResolveReferences() returned no error", tt.name)
+		}
+	}
+}
+
+func TestSortStructsByReferences(t *testing.T) {
+	comment := &structToCreate{structName: "Comment", cols: []*column{{refStruct: "Blog"}, {refStruct: "User"}}}
+	blog := &structToCreate{structName: "Blog", cols: []*column{{refStruct: "User"}, {refStruct: "Blog"}}}
+	user := &structToCreate{structName: "User", cols: []*column{{refStruct: "Team"}}}
+	team := &structToCreate{structName: "Team", cols: []*column{{refStruct: "User"}}}
+	var got []string
+	for _, struc := range SortStructsByReferences([]*structToCreate{comment, blog, user, team}) {
+		got = append(got, struc.structName)
+	}
+	want := "Team User Blog Comment"
+	if strings.Join(got, " ") != want {
+		t.Errorf("SortStructsByReferences() = %v, want %s", got, want)
+	}
+}
+
+func TestRefName(t *testing.T) {
+	tests := []struct {
+		varName string
+		want    string
+	}{
+		{"UserID", "User"},
+		{"authorId", "Author"},
+		{"Owner", "OwnerUser"},
+		{"ID", "IDUser"},
+	}
+	for _, tt := range tests {
+		col := &column{varName: tt.varName, refStruct: "User"}
+		if got := col.RefName(); got != tt.want {
+			t.Errorf("RefName(%q) = %q, want %q", tt.varName, got, tt.want)
+		}
+	}
+}
+
+func TestResolveJoins(t *testing.T) {
+	user := &structToCreate{structName: "User", tableName: "users", cols: []*column{{colName: "id", primary: true}}}
+	role := &structToCreate{structName: "Role", tableName: "role", cols: []*column{{colName: "id", primary: true}}}
+	join := &manyToMany{structNames: [2]string{"user", "Role"}}
+	if err := ResolveJoins([]*structToCreate{user, role}, []*manyToMany{join}); err != nil {
+		t.Fatalf("ResolveJoins() returned error: %v", err)
+	}
+	if join.tableName != "users_role" || join.colNames != [2]string{"users_id", "role_id"} {
+		t.Errorf("join table = %s %v, want users_role [users_id role_id]", join.tableName, join.colNames)
+	}
+	if len(user.joins) != 1 || len(role.joins) != 1 {
+		t.Errorf("joins weren't added to both structs")
+	}
+
+	errTests := []struct {
+		name  string
+		names [2]string
+	}{
+		{"unknown struct", [2]string{"User", "Team"}},
+		{"self join", [2]string{"User", "user"}},
+	}
+	for _, tt := range errTests {
+		if err := ResolveJoins([]*structToCreate{user, role}, []*manyToMany{{structNames: tt.names}}); err == nil {
+			t.Errorf("%s: ResolveJoins() returned no error", tt.name)
+		}
+	}
+	blog := &structToCreate{structName: "Blog", cols: []*column{{colName: "blog_id", primary: true}, {colName: "user_id", refStruc: user}}}
+	if err := ResolveJoins([]*structToCreate{user, blog}, []*manyToMany{{structNames: [2]string{"User", "Blog"}}}); err == nil {
+		t.Errorf("expected an error when a [references] column already links the structs")
+	}
+}
+
+func TestCheckUniqueKeys(t *testing.T) {
+	title := &column{varName: "Title", colName: "title", uniqGroups: []string{"slug"}}
+	email := &column{varName: "Email", colName: "email", unique: true}
+	catID := &column{varName: "CategoryID", colName: "category_id", uniqGroups: []string{"slug"}}
+	s := &structToCreate{cols: []*column{{varName: "BlogID", primary: true}, title, email, catID}}
+	keys := s.UniqueKeys()
+	if len(keys) != 2 || keys[0].name != "email" || keys[1].name != "slug" || len(keys[1].cols) != 2 {
+		t.Fatalf("UniqueKeys() = %v, want email then slug with two columns", keys)
+	}
+	if got := keys[1].MethodSuffix(); got != "ByTitleAndCategoryID" {
+		t.Errorf("MethodSuffix() = %q, want ByTitleAndCategoryID", got)
+	}
+	if err := s.CheckUniqueKeys(); err != nil {
+		t.Errorf("CheckUniqueKeys() returned error: %v", err)
+	}
+	email.uniqGroups = []string{"email"}
+	if err := s.CheckUniqueKeys(); err == nil {
+		t.Errorf("expected an error when a group and a [unique] column share a name")
+	}
+	email.uniqGroups = nil
+	s.cols[0].unique = true
+	if err := s.CheckUniqueKeys(); err == nil {
+		t.Errorf("expected an error for a [unique] primary key")
+	}
+}
+
+func TestCheckIndexKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		cols    []*column
+		wantErr bool
+	}{
+		{"group", []*column{{varName: "A", indexGroups: []string{"ab"}, indexDesc: true}, {varName: "B", indexGroups: []string{"ab"}}}, false},
+		{"option without index", []*column{{varName: "A", indexUsing: "gin"}}, true},
+		{"partial without deleted", []*column{{varName: "A", index: true, partial: true}}, true},
+		{"partial with predicate", []*column{{varName: "A", index: true, partial: true, where: "a > 0"}}, false},
+		{"mixed methods", []*column{{varName: "A", indexGroups: []string{"ab"}, indexUsing: "gin"}, {varName: "B", indexGroups: []string{"ab"}, indexUsing: "brin"}}, true},
+		{"hash group", []*column{{varName: "A", indexGroups: []string{"ab"}, indexUsing: "hash"}, {varName: "B", indexGroups: []string{"ab"}}}, true},
+		{"desc with gin", []*column{{varName: "A", index: true, indexUsing: "gin", indexDesc: true}}, true},
+		{"same columns", []*column{{varName: "A", index: true, indexGroups: []string{"a2"}}}, true},
+	}
+	for _, tt := range tests {
+		struc := &structToCreate{structName: "Blog", cols: tt.cols}
+		if err := struc.CheckIndexKeys(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: CheckIndexKeys() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestInsertValue(t *testing.T) {
+	tests := []struct {
+		col         column
+		insertZeros bool
+		want        string
+	}{
+		{column{goType: "int", dbType: "integer"}, false, "$1"},
+		{column{goType: "int", dbType: "integer", defaultExpr: "5"}, false, "COALESCE(NULLIF($1::integer, 0), 5)"},
+		{column{goType: "int", dbType: "integer", defaultExpr: "5"}, true, "$1"},
+		{column{goType: "string", dbType: "text", defaultExpr: "'draft'"}, false, "COALESCE(NULLIF($1::text, ''), 'draft')"},
+		{column{goType: "nulls.String", dbType: "text", nulls: true, defaultExpr: "'draft'"}, false, "COALESCE($1::text, 'draft')"},
+	}
+	for _, tt := range tests {
+		if got := tt.col.InsertValue("$1", tt.insertZeros); got != tt.want {
+			t.Errorf("InsertValue(%s, %v) = %q, want %q", tt.col.goType, tt.insertZeros, got, tt.want)
+		}
+	}
+}
+
+func TestKeyInsertValue(t *testing.T) {
+	tests := []struct {
+		col       column
+		sequenced bool
+		want      string
+	}{
+		{column{primary: true, goType: "int64"}, true, "$1"},
+		{column{primary: true, goType: "string"}, false, "$1"},
+		{column{primary: true, goType: "string", uuid: true}, false, "COALESCE(NULLIF($1::text, '')::uuid, gen_random_uuid())"},
+		{column{primary: true, goType: "uuid.UUID", uuid: true}, false, "COALESCE(NULLIF($1::uuid, '00000000-0000-0000-0000-000000000000'), gen_random_uuid())"},
+	}
+	for _, tt := range tests {
+		if got := tt.col.Sequenced(); got != tt.sequenced {
+			t.Errorf("%s: Sequenced() = %v, want %v", tt.col.goType, got, tt.sequenced)
+		}
+		if got := tt.col.KeyInsertValue("$1"); got != tt.want {
+			t.Errorf("%s: KeyInsertValue() = %q, want %q", tt.col.goType, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPrimaryKeys(t *testing.T) {
+	org := &column{colName: "org_id", varName: "OrgID", goType: "int64", primary: true}
+	tenant := &column{colName: "tenant", varName: "Tenant", goType: "uuid.UUID", primary: true, uuid: true}
+	member := &structToCreate{structName: "Member", tableName: "member", cols: []*column{org, tenant, {colName: "role", goType: "string"}}}
+	if err := member.CheckPrimaryKeys(); err != nil {
+		t.Fatalf("CheckPrimaryKeys() returned error: %v", err)
+	}
+	if !org.keyPart || !tenant.keyPart {
+		t.Errorf("key parts weren't marked")
+	}
+	if org.Sequenced() {
+		t.Errorf("a composite key part shouldn't be sequenced")
+	}
+	if got := tenant.KeyInsertValue("$2"); got != "$2" {
+		t.Errorf("KeyInsertValue() = %q, want $2", got)
+	}
+
+	bad := &structToCreate{structName: "Bad", cols: []*column{{varName: "A", primary: true}, {varName: "B", primary: true, nulls: true}}}
+	if err := bad.CheckPrimaryKeys(); err == nil {
+		t.Errorf("expected an error for a [nulls] key part")
+	}
+	blog := &structToCreate{structName: "Blog", cols: []*column{{colName: "blog_id", primary: true}, {varName: "MemberID", refStruct: "Member"}}}
+	if err := ResolveReferences([]*structToCreate{member, blog}); err == nil {
+		t.Errorf("expected an error when [references] points at a composite key")
+	}
+}
+
+func TestNextvalExpr(t *testing.T) {
+	id := &column{colName: "id", goType: "int", primary: true}
+	struc := &structToCreate{structName: "User", schema: "public", tableName: "users", cols: []*column{id}}
+	if got, want := struc.NextvalExpr(id), "nextval('public.users_id_seq'::regclass)"; got != want {
+		t.Errorf("NextvalExpr() = %q, want %q", got, want)
+	}
+	struc.identity = true
+	if got, want := struc.NextvalExpr(id), "nextval(pg_get_serial_sequence('public.users', 'id'))"; got != want {
+		t.Errorf("NextvalExpr() with [identity] = %q, want %q", got, want)
+	}
+	if err := struc.CheckPrimaryKeys(); err != nil {
+		t.Errorf("CheckPrimaryKeys() returned error: %v", err)
+	}
+	id.goType = "string"
+	if err := struc.CheckPrimaryKeys(); err == nil {
+		t.Errorf("expected an error for [identity] on a string key")
+	}
+}
+
+func TestExtendedTypes(t *testing.T) {
+	tests := []struct {
+		goType, dbType, kind string
+	}{
+		{"[]string", "text[]", "array"},
+		{"[]int64", "bigint[]", "array"},
+		{"json.RawMessage", "jsonb", "jsonb"},
+		{"map[string]interface{}", "jsonb", "jsonb"},
+		{"time.Duration", "interval", "interval"},
+		{"net.IP", "inet", "inet"},
+		{"decimal.Decimal", "numeric", ""},
+	}
+	for _, tt := range tests {
+		col := &column{varName: "V", colName: "v", goType: tt.goType}
+		if ok, _ := col.MapGoTypeToDBTypes(); !ok {
+			t.Errorf("MapGoTypeToDBTypes() failed for %s", tt.goType)
+			continue
+		}
+		if col.dbType != tt.dbType || col.ValueKind() != tt.kind {
+			t.Errorf("%s mapped to %q (%q), want %q (%q)", tt.goType, col.dbType, col.ValueKind(), tt.dbType, tt.kind)
+		}
+	}
+
+	ttl := &column{varName: "Ttl", colName: "ttl", goType: "time.Duration", dbType: "interval"}
+	if got, want := ttl.SelectExpr(), "(EXTRACT(EPOCH FROM ttl) * 1000000000)::bigint"; got != want {
+		t.Errorf("SelectExpr() = %q, want %q", got, want)
+	}
+	price := &column{varName: "Price", goType: "float64", dbType: "double precision", precision: "12, 2"}
+	if err := price.ApplyTypeOptions(); err != nil || price.dbType != "numeric(12,2)" {
+		t.Errorf("ApplyTypeOptions() = %q, %v, want numeric(12,2)", price.dbType, err)
+	}
+	price.precision = "12,2,1"
+	if err := price.ApplyTypeOptions(); err == nil {
+		t.Errorf("expected an error for [precision:12,2,1]")
+	}
+	seen := &column{varName: "Seen", goType: "time.Time", timezone: true}
+	if err := seen.ApplyTypeOptions(); err != nil || seen.dbType != "timestamp with time zone" {
+		t.Errorf("ApplyTypeOptions() = %q, %v, want timestamp with time zone", seen.dbType, err)
+	}
+	seen.goType = "string"
+	if err := seen.ApplyTypeOptions(); err == nil {
+		t.Errorf("expected an error for [timezone] on a string")
+	}
+}
+
+func TestApplyDBType(t *testing.T) {
+	status := &column{varName: "Status", goType: "OrderStatus", customDB: "order_status"}
+	if ok, _ := status.MapGoTypeToDBTypes(); ok {
+		t.Fatalf("OrderStatus shouldn't have a mapped type")
+	}
+	status.customType = true
+	if err := status.ApplyDBType(); err != nil || status.dbType != "order_status" {
+		t.Errorf("ApplyDBType() = %q, %v, want order_status", status.dbType, err)
+	}
+	if kind := status.ValueKind(); kind != "" {
+		t.Errorf("ValueKind() = %q for a custom type, want \"\"", kind)
+	}
+	if got, want := status.ChangedExpr("a", "b"), "!reflect.DeepEqual(a.Status, b.Status)"; got != want {
+		t.Errorf("ChangedExpr() = %q, want %q", got, want)
+	}
+
+	tags := &column{varName: "Tags", goType: "[]string", customDB: "varchar(20)[]"}
+	tags.MapGoTypeToDBTypes()
+	if err := tags.ApplyDBType(); err != nil || tags.dbType != "varchar(20)[]" || tags.ValueKind() != "array" {
+		t.Errorf("ApplyDBType() = %q (%q), %v, want a varchar(20)[] array", tags.dbType, tags.ValueKind(), err)
+	}
+	bad := &column{varName: "Bad", goType: "string", customDB: `text; drop table x`}
+	if err := bad.ApplyDBType(); err == nil {
+		t.Errorf("expected an error for a [dbtype] with a semicolon")
+	}
+	bad = &column{varName: "Bad", goType: "float64", customDB: "money", precision: "10,2"}
+	if err := bad.ApplyDBType(); err == nil {
+		t.Errorf("expected an error for [dbtype] with [precision]")
+	}
+	key := &structToCreate{structName: "Order", cols: []*column{{varName: "ID", goType: "OrderID", primary: true, customType: true}}}
+	if err := key.CheckPrimaryKeys(); err == nil {
+		t.Errorf("expected an error for a custom type primary key")
+	}
+}
+
+func TestParseEnum(t *testing.T) {
+	enum, err := ParseEnum([]string{"OrderStatus", "pending", "on_hold"}, true, "public")
+	if err != nil {
+		t.Fatalf("ParseEnum() returned error: %v", err)
+	}
+	if enum.dbName != "order_status" || enum.QualifiedName() != "public.order_status" {
+		t.Errorf("ParseEnum() named the type %q (%q)", enum.dbName, enum.QualifiedName())
+	}
+	if got := enum.ConstName("on_hold"); got != "OrderStatusOnHold" {
+		t.Errorf("ConstName(on_hold) = %q, want OrderStatusOnHold", got)
+	}
+	if _, err := ParseEnum([]string{"OrderStatus"}, true, "public"); err == nil {
+		t.Errorf("expected an error for an enum without values")
+	}
+	if _, err := ParseEnum([]string{"OrderStatus", "on-hold"}, true, "public"); err == nil {
+		t.Errorf("expected an error for a value that can't be a Go constant")
+	}
+	if _, err := ParseEnum([]string{"OrderStatus", "pending", "pending"}, true, "public"); err == nil {
+		t.Errorf("expected an error for a repeated value")
+	}
+	if err := CheckEnums([]*enumType{enum}); err == nil {
+		t.Errorf("expected an error for an enum no struct uses")
+	}
+}
+
+func TestEmbedNested(t *testing.T) {
+	country := &enumType{typeName: "Country"}
+	geo := &structToCreate{structName: "Geo", isNested: true, cols: []*column{{varName: "Lat", colName: "lat", goType: "float64"}}}
+	address := &structToCreate{structName: "Address", isNested: true, cols: []*column{{varName: "City", colName: "city", goType: "string", structLine: "City string"}}, enums: []*enumType{country}}
+	address.Embed(geo, "geo")
+	customer := &structToCreate{structName: "Customer", cols: []*column{{varName: "CustomerID", colName: "customer_id", primary: true}}}
+	customer.Embed(address, "address")
+
+	var colNames []string
+	for _, col := range customer.cols {
+		colNames = append(colNames, col.colName)
+	}
+	if got, want := strings.Join(colNames, ","), "customer_id,address_city,address_geo_lat"; got != want {
+		t.Errorf("Embed() made the columns %s, want %s", got, want)
+	}
+	if customer.cols[2].embed != address || address.cols[1].embed != geo {
+		t.Errorf("flattened columns should point at the struct they were embedded from")
+	}
+	if geo.owner != customer || country.owner != customer || len(customer.nestedTypes) != 2 || len(customer.enums) != 1 {
+		t.Errorf("the types used by Address should be written with Customer")
+	}
+	if err := customer.CheckNested(); err != nil {
+		t.Errorf("CheckNested() returned error: %v", err)
+	}
+	if got, want := BuildStructFields(address.cols), "City string\nGeo\n"; got != want {
+		t.Errorf("BuildStructFields() = %q, want %q", got, want)
+	}
+
+	customer.Embed(geo, "geo")
+	if err := customer.CheckNested(); err == nil {
+		t.Errorf("expected an error for a Lat variable that is embedded twice")
+	}
+	geo.cols = append(geo.cols, &column{varName: "Version", colName: "version", version: true})
+	if err := geo.CheckNested(); err == nil {
+		t.Errorf("expected an error for [version] in a [nested struct]")
+	}
+}
+
+func TestMapNullTypes(t *testing.T) {
+	tests := []struct {
+		goType    string
+		style     string
+		want      string
+		valueExpr string
+		isNull    string
+	}{
+		{"int", "nulls", "nulls.Int", "u.Age.Int", "!u.Age.Valid"},
+		{"int16", "sql", "sql.NullInt16", "u.Age.Int16", "!u.Age.Valid"},
+		{"uint64", "sql", "sql.Null[uint64]", "u.Age.V", "!u.Age.Valid"},
+		{"time.Time", "sql", "sql.NullTime", "u.Age.Time", "!u.Age.Valid"},
+		{"int16", "pointer", "*int16", "*u.Age", "u.Age == nil"},
+	}
+	for _, tt := range tests {
+		col := &column{varName: "Age", goType: tt.goType}
+		if err := col.MapNullTypes(tt.style); err != nil {
+			t.Errorf("%s %s: MapNullTypes() returned error: %v", tt.style, tt.goType, err)
+			continue
+		}
+		if col.goType != tt.want || col.ArgType() != tt.goType {
+			t.Errorf("%s %s: mapped to %s (%s), want %s", tt.style, tt.goType, col.goType, col.ArgType(), tt.want)
+		}
+		if got := col.ValueExpr("u"); got != tt.valueExpr {
+			t.Errorf("%s %s: ValueExpr() = %q, want %q", tt.style, tt.goType, got, tt.valueExpr)
+		}
+		if got := col.IsNullExpr("u"); got != tt.isNull {
+			t.Errorf("%s %s: IsNullExpr() = %q, want %q", tt.style, tt.goType, got, tt.isNull)
+		}
+	}
+	if err := (&column{varName: "Age", goType: "int16"}).MapNullTypes("nulls"); err == nil {
+		t.Errorf("expected an error for an int16 with the nulls package")
+	}
+	if _, err := ParseNullsStyle("pointers"); err != nil {
+		t.Errorf("ParseNullsStyle() returned error: %v", err)
+	}
+	if _, err := ParseNullsStyle("optional"); err == nil {
+		t.Errorf("expected an error for an unknown [nulls style]")
+	}
+}
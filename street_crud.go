@@ -24,6 +24,10 @@ func main() {
 	var packageName string
 	var reqVarCount uint8
 	var structsToAdd []*structToCreate
+	var joins []*manyToMany
+	var enums []*enumType
+	var nestedStructs []*structToCreate
+	var nullsStyle string = "nulls"
 	var structFromFile *structToCreate
 
 	var filePath string
@@ -208,6 +212,13 @@ func main() {
 								structFromFile.actionType = "Add"
 								structFromFile.prepared = true
 								continue LineParsed
+							case "[nested struct]":
+								//a struct without a table, embedded in or used as a variable of the structs after it
+								inAddStructState = true
+								inCollectState = false
+								structFromFile = new(structToCreate)
+								structFromFile.isNested = true
+								continue LineParsed
 							case "[alter table]":
 								inAlterStructState = true
 								inCollectState = false
@@ -225,6 +236,45 @@ func main() {
 								structFromFile.actionType = tblToAlter
 								structFromFile.prepared = true
 								continue LineParsed
+							case "[many to many]":
+								//two struct names and an optional join table name
+								joinNames := strings.Fields(string(sLine[letterIndex+1:]))
+								if len(joinNames) < 2 || len(joinNames) > 3 {
+									fmt.Print(processFail + "[many to many] needs two struct names and an optional join table name.\n")
+									return
+								}
+								join := &manyToMany{structNames: [2]string{joinNames[0], joinNames[1]}}
+								if len(joinNames) == 3 {
+									join.tableName = strings.ToLower(joinNames[2])
+									if err := CheckColAndTblNames(join.tableName); err != nil {
+										fmt.Println(processFail + err.Error())
+										return
+									}
+								}
+								joins = append(joins, join)
+								continue LineParsed
+							case "[enum]":
+								//a Go type name followed by its values, e.g. [enum] OrderStatus pending shipped
+								enum, errEnum := ParseEnum(strings.Fields(string(sLine[letterIndex+1:])), useUnderscore, schemaName)
+								if errEnum != nil {
+									fmt.Println(processFail + errEnum.Error())
+									return
+								}
+								if FindEnum(enums, enum.typeName) != nil {
+									fmt.Println(processFail + "[enum] " + enum.typeName + " is defined more than once.")
+									return
+								}
+								enums = append(enums, enum)
+								continue LineParsed
+							case "[nulls style]":
+								//optional, the types of the [nulls] variables in the structs after it
+								style, errStyle := ParseNullsStyle(strings.TrimSpace(string(sLine[letterIndex+1:])))
+								if errStyle != nil {
+									fmt.Println(processFail + errStyle.Error())
+									return
+								}
+								nullsStyle = style
+								continue LineParsed
 							} //switch
 						}
 
@@ -286,6 +336,53 @@ func main() {
 										}
 									}
 									continue LineParsed
+								case "[concurrent indexes]":
+									if utf8.RuneCountInString(sLine) > letterIndex+1 {
+										concurrently := strings.ToLower(strings.TrimSpace(string(sLine[letterIndex+1:])))
+										structFromFile.concurrently = concurrently == "true" || concurrently == "t"
+									}
+									continue LineParsed
+								case "[identity]":
+									if utf8.RuneCountInString(sLine) > letterIndex+1 {
+										identity := strings.ToLower(strings.TrimSpace(string(sLine[letterIndex+1:])))
+										structFromFile.identity = identity == "true" || identity == "t"
+									}
+									continue LineParsed
+								case "[insert zeros]":
+									if utf8.RuneCountInString(sLine) > letterIndex+1 {
+										insertZeros := strings.ToLower(strings.TrimSpace(string(sLine[letterIndex+1:])))
+										structFromFile.insertZeros = insertZeros == "true" || insertZeros == "t"
+									}
+									continue LineParsed
+								case "[check]":
+									//table level CHECK constraint, keep the case since it is SQL
+									check := strings.TrimSpace(string(sLine[letterIndex+1:]))
+									if check == "" {
+										fmt.Print(processFail + "[check] needs an expression, e.g. [check] starts < ends.\n")
+										return
+									}
+									structFromFile.checks = append(structFromFile.checks, check)
+									continue LineParsed
+								case "[track changes]":
+									if utf8.RuneCountInString(sLine) > letterIndex+1 {
+										trackChanges := strings.ToLower(strings.TrimSpace(string(sLine[letterIndex+1:])))
+										structFromFile.trackChanges = trackChanges == "true" || trackChanges == "t"
+									}
+									continue LineParsed
+								default:
+									//[upsert] conflicts on the primary key, [upsert:Var1,Var2] on the listed columns
+									keyword := strings.ToLower(string(bracks))
+									if keyword == "[upsert]" || strings.HasPrefix(keyword, "[upsert:") {
+										structFromFile.hasUpsert = true
+										if keyword != "[upsert]" {
+											for _, upsertVar := range strings.Split(keyword[8:len(keyword)-1], ",") {
+												if upsertVar = strings.TrimSpace(upsertVar); upsertVar != "" {
+													structFromFile.upsertVars = append(structFromFile.upsertVars, upsertVar)
+												}
+											}
+										}
+										continue LineParsed
+									}
 								} //switch
 							}
 						} else if cLetter == 't' || cLetter == 'T' {
@@ -341,8 +438,52 @@ func main() {
 								//columns are finished being read, end Add states
 								inAddStructState = false
 								inCollectStructState = false
+								if err := structFromFile.CheckNested(); err != nil {
+									fmt.Println(processFail + err.Error())
+									return
+								}
+								if structFromFile.isNested {
+									if FindNested(nestedStructs, structFromFile.structName) != nil {
+										fmt.Println(processFail + "[nested struct] " + structFromFile.structName + " is defined more than once.")
+										return
+									}
+									nestedStructs = append(nestedStructs, structFromFile)
+									continue LineParsed
+								}
 								if !structFromFile.hasKey {
-									fmt.Println(processFail + "At least one column of type integer must be marked with the keyword [Primary].")
+									fmt.Println(processFail + "At least one column must be marked with the keyword [Primary]. Keys can be integers (with a sequence), strings, or uuid.UUID.")
+									return
+								}
+								if err := structFromFile.CheckPrimaryKeys(); err != nil {
+									fmt.Println(processFail + err.Error())
+									return
+								}
+								if err := structFromFile.CheckPatchGroups(); err != nil {
+									fmt.Println(processFail + err.Error())
+									return
+								}
+								if err := structFromFile.CheckVersionCol(); err != nil {
+									fmt.Println(processFail + err.Error())
+									return
+								}
+								if err := structFromFile.CheckTimestampCols(); err != nil {
+									fmt.Println(processFail + err.Error())
+									return
+								}
+								if err := structFromFile.CheckUniqueKeys(); err != nil {
+									fmt.Println(processFail + err.Error())
+									return
+								}
+								if err := structFromFile.CheckIndexKeys(); err != nil {
+									fmt.Println(processFail + err.Error())
+									return
+								}
+								if err := structFromFile.CheckDefaults(); err != nil {
+									fmt.Println(processFail + err.Error())
+									return
+								}
+								if err := structFromFile.ResolveUpsertCols(); err != nil {
+									fmt.Println(processFail + err.Error())
 									return
 								}
 								structFromFile.database = dbName
@@ -350,6 +491,20 @@ func main() {
 								structsToAdd = append(structsToAdd, structFromFile)
 								continue LineParsed
 							}
+							//An embedded [nested struct] adds its columns with the struct's name as a prefix
+							if lineColumn := strings.Fields(sLine); len(lineColumn) == 1 {
+								nested := FindNested(nestedStructs, lineColumn[0])
+								if nested == nil {
+									fmt.Println(processFail + lineColumn[0] + " can only be embedded if it is a [nested struct] defined above " + structFromFile.structName + ".")
+									return
+								}
+								prefix := strings.ToLower(nested.structName)
+								if useUnderscore {
+									prefix, _ = ConvertToUnderscore(nested.structName)
+								}
+								structFromFile.Embed(nested, prefix)
+								continue LineParsed
+							}
 							//Collect column, type, json, bracks
 							if strings.TrimSpace(sLine) != "" {
 								if lineColumn := strings.Split(TrimInnerSpacesToOne(sLine), " "); len(lineColumn) > 1 {
@@ -389,29 +544,28 @@ func main() {
 										wasTypeAssigned = false
 										switch {
 										case userOptions == "primary]":
-											if !structFromFile.hasKey {
-												switch strings.ToLower(col.goType) {
-												case "int", "int8", "int16", "int32", "uint", "uint8", "uint16", "uint32", "uintptr":
-													col.dbType = "integer"
-												case "int64", "uint64":
-													col.dbType = "bigint"
-												default:
-													fmt.Println(processFail + "Not a known primary key type. StreetCRUD only supports auto incrementing integers at this point.")
-													return
-												}
-												col.primary = true
-												wasTypeAssigned = true
-												structFromFile.hasKey = true
-												//Find and store the primary key column from the old table
-												for i, newCol := range structFromFile.newAltCols {
-													if newCol == col.colName {
-														structFromFile.oldColPrim = structFromFile.oldAltCols[i]
-													}
-												}
-											} else {
-												fmt.Println(processFail + "The [primary] keyword can only be used on one column per struct definition.")
+											//several [primary] columns make a composite key
+											switch strings.ToLower(col.goType) {
+											case "int", "int8", "int16", "int32", "uint", "uint8", "uint16", "uint32", "uintptr":
+												col.dbType = "integer"
+											case "int64", "uint64":
+												col.dbType = "bigint"
+											case "string", "uuid.uuid":
+												//natural keys are supplied by the caller, [uuid] keys default to gen_random_uuid()
+												col.MapGoTypeToDBTypes()
+											default:
+												fmt.Println(processFail + "Not a known primary key type. StreetCRUD supports integers (with a sequence), strings, and UUIDs.")
 												return
 											}
+											col.primary = true
+											wasTypeAssigned = true
+											structFromFile.hasKey = true
+											//Find and store the primary key column from the old table
+											for i, newCol := range structFromFile.newAltCols {
+												if newCol == col.colName {
+													structFromFile.oldColPrim = structFromFile.oldAltCols[i]
+												}
+											}
 										case strings.Contains(userOptions, "size:"):
 											if col.goType != "string" {
 												fmt.Println(processFail + "[size] can only be used with type string.")
@@ -420,8 +574,69 @@ func main() {
 											col.size = userOptions[5:strings.IndexRune(userOptions, ']')]
 										case userOptions == "index]":
 											col.index = true
+										case strings.HasPrefix(userOptions, "index:"):
+											group := strings.TrimSpace(userOptions[6:strings.IndexRune(userOptions, ']')])
+											if errNaming := CheckColAndTblNames(group); errNaming != nil {
+												fmt.Println(processFail + "[index:group] issue: " + errNaming.Error())
+												return
+											}
+											col.indexGroups = append(col.indexGroups, group)
+										case strings.HasPrefix(userOptions, "using:"):
+											col.indexUsing = strings.TrimSpace(userOptions[6:strings.IndexRune(userOptions, ']')])
+											switch col.indexUsing {
+											case "btree", "hash", "gin", "gist", "brin":
+											default:
+												fmt.Println(processFail + "[using] must be btree, hash, gin, gist or brin.")
+												return
+											}
+										case userOptions == "desc]":
+											col.indexDesc = true
+										case userOptions == "partial]":
+											col.partial = true
+										case strings.HasPrefix(userOptions, "partial:"):
+											//keep the case of the predicate since it is SQL
+											rawOption := strings.TrimSpace(scOptsColumn[i])
+											col.partial = true
+											col.where = strings.TrimSpace(rawOption[8:strings.LastIndex(rawOption, "]")])
+											if col.where == "" {
+												fmt.Println(processFail + "[partial:predicate] needs a predicate, e.g. [partial:status <> 'closed'].")
+												return
+											}
+										case strings.HasPrefix(userOptions, "default:"):
+											//keep the case of the expression since it is SQL
+											rawOption := strings.TrimSpace(scOptsColumn[i])
+											col.defaultExpr = strings.TrimSpace(rawOption[8:strings.LastIndex(rawOption, "]")])
+											if col.defaultExpr == "" {
+												fmt.Println(processFail + "[default:expr] needs an expression, e.g. [default:'draft'].")
+												return
+											}
+										case strings.HasPrefix(userOptions, "check:"):
+											rawOption := strings.TrimSpace(scOptsColumn[i])
+											col.check = strings.TrimSpace(rawOption[6:strings.LastIndex(rawOption, "]")])
+											if col.check == "" {
+												fmt.Println(processFail + "[check:expr] needs an expression, e.g. [check:price >= 0].")
+												return
+											}
+										case userOptions == "unique]":
+											col.unique = true
+										case strings.HasPrefix(userOptions, "unique:"):
+											group := strings.TrimSpace(userOptions[7:strings.IndexRune(userOptions, ']')])
+											if errNaming := CheckColAndTblNames(group); errNaming != nil {
+												fmt.Println(processFail + "[unique:group] issue: " + errNaming.Error())
+												return
+											}
+											col.uniqGroups = append(col.uniqGroups, group)
 										case userOptions == "patch]":
 											col.patch = true
+										case strings.HasPrefix(userOptions, "patch:"):
+											//keep the case of the group name for the generated method name
+											rawOption := strings.TrimSpace(scOptsColumn[i])
+											group := strings.TrimSpace(rawOption[6:strings.IndexRune(rawOption, ']')])
+											if errNaming := CheckColAndTblNames(group); errNaming != nil {
+												fmt.Println(processFail + "[patch:group] issue: " + errNaming.Error())
+												return
+											}
+											col.patchGroups = append(col.patchGroups, group)
 										case userOptions == "deleted]":
 											if strings.ToLower(col.goType) != "bool" {
 												fmt.Println(processFail + "A column marked as [deleted] must have the type bool.")
@@ -439,27 +654,145 @@ func main() {
 											}
 											col.deletedOn = true
 											wasTypeAssigned = true
+										case strings.HasPrefix(userOptions, "references:"):
+											//keep the case of a struct name for error messages
+											rawOption := strings.TrimSpace(scOptsColumn[i])
+											if errRef := col.ParseReferences(rawOption[11:strings.IndexRune(rawOption, ']')]); errRef != nil {
+												fmt.Println(processFail + "[references] issue: " + errRef.Error())
+												return
+											}
+											//foreign key columns are indexed for the generated loaders and GetBy methods
+											col.index = true
+										case strings.HasPrefix(userOptions, "ondelete:"), strings.HasPrefix(userOptions, "onupdate:"):
+											action, errAction := ParseReferentialAction(userOptions[9:strings.IndexRune(userOptions, ']')])
+											if errAction != nil {
+												fmt.Println(processFail + errAction.Error())
+												return
+											}
+											if strings.HasPrefix(userOptions, "ondelete:") {
+												col.onDelete = action
+											} else {
+												col.onUpdate = action
+											}
+										case userOptions == "createdon]" || userOptions == "updatedon]":
+											if strings.ToLower(col.goType) != "time.time" {
+												fmt.Println(processFail + "A column marked as [createdOn] or [updatedOn] must have the type time.Time.")
+												return
+											}
+											col.dbType = "timestamp without time zone"
+											col.createdOn = col.createdOn || userOptions == "createdon]"
+											col.updatedOn = col.updatedOn || userOptions == "updatedon]"
+											wasTypeAssigned = true
+										case userOptions == "version]":
+											switch strings.ToLower(col.goType) {
+											case "int", "int8", "int16", "int32", "uint", "uint8", "uint16", "uint32":
+												col.dbType = "integer"
+											case "int64", "uint64":
+												col.dbType = "bigint"
+											default:
+												fmt.Println(processFail + "A column marked as [version] must have an integer type.")
+												return
+											}
+											col.version = true
+											wasTypeAssigned = true
 										case userOptions == "ignore]":
 											//ignore this line of the input struct
 											col = nil
 											continue LineParsed
 										case userOptions == "nulls]":
 											col.nulls = true
-											structFromFile.nullsPkg = true
+										case userOptions == "uuid]":
+											if strings.ToLower(col.goType) != "string" && strings.ToLower(col.goType) != "uuid.uuid" {
+												fmt.Println(processFail + "A column marked as [uuid] must have the type string or uuid.UUID.")
+												return
+											}
+											col.uuid = true
+										case strings.HasPrefix(userOptions, "precision:"):
+											col.precision = userOptions[10:strings.IndexRune(userOptions, ']')]
+										case userOptions == "timezone]":
+											col.timezone = true
+										case userOptions == "jsonb]":
+											col.jsonb = true
+										case strings.HasPrefix(userOptions, "dbtype:"):
+											//keep the case of the type and put back the brackets of array types, e.g. [dbtype:citext[]]
+											rawOption := strings.TrimSpace(scOptsColumn[i])
+											for i+1 < len(scOptsColumn) && strings.HasPrefix(scOptsColumn[i+1], "]") {
+												i++
+												rawOption += "[" + strings.TrimSpace(scOptsColumn[i])
+											}
+											col.customDB = strings.TrimSpace(rawOption[7:strings.LastIndex(rawOption, "]")])
+											if col.customDB == "" {
+												fmt.Println(processFail + "[dbtype:type] needs a Postgres type, e.g. [dbtype:citext].")
+												return
+											}
 										}
 
 									} //for i < len(scOptsColumn)
 
-									if !wasTypeAssigned {
+									//a pointer variable is nullable, its column has the type it points to
+									if strings.HasPrefix(col.goType, "*") && FindNested(nestedStructs, col.goType) == nil && !col.jsonb {
+										col.nulls = true
+										col.nullStyle = "pointer"
+										col.baseType = col.goType[1:]
+									}
+
+									if enum := FindEnum(enums, col.ArgType()); enum != nil && !wasTypeAssigned {
+										//the enum's values are checked by its Go type, so only a pointer can hold NULL
+										if (col.nulls && col.nullStyle != "pointer" && nullsStyle != "pointer") || col.customDB != "" {
+											fmt.Println(processFail + "The [enum] variable " + col.varName + " can't be [nulls] unless it's a pointer, or have a [dbtype].")
+											return
+										}
+										col.enum = enum
+										col.dbType = enum.QualifiedName()
+										if enum.owner == nil {
+											enum.owner = structFromFile
+											structFromFile.enums = append(structFromFile.enums, enum)
+										}
+									} else if nested := FindNested(nestedStructs, col.goType); (nested != nil || col.jsonb) && !wasTypeAssigned {
+										//a [nested struct] variable is stored as jsonb, [jsonb] does the same for other types
+										if col.nulls || col.customDB != "" {
+											fmt.Println(processFail + "The jsonb variable " + col.varName + " can't be [nulls] or have a [dbtype].")
+											return
+										}
+										col.jsonb = true
+										col.dbType = "jsonb"
+										if nested != nil {
+											structFromFile.UseNested(nested)
+										}
+									} else if !wasTypeAssigned {
 										//map goType to dbType if a dbType wasn't assigned above
-										if check, msg := col.MapGoTypeToDBTypes(); !check {
+										//a type that isn't mapped is passed to Scan and Exec as is when it has a [dbtype]
+										if check, msg := col.MapGoTypeToDBTypes(); !check && col.customDB == "" {
 											fmt.Println(processFail + msg)
 											return
+										} else if !check {
+											col.customType = true
 										}
 									}
+									if strings.ToLower(col.ArgType()) == "uuid.uuid" {
+										col.uuid = true
+									}
+									if col.uuid {
+										col.dbType = "uuid"
+									}
+									if err := col.ApplyTypeOptions(); err != nil {
+										fmt.Println(processFail + err.Error())
+										return
+									}
+									if err := col.ApplyDBType(); err != nil {
+										fmt.Println(processFail + err.Error())
+										return
+									}
+
+									//the driver values of these types are built from the variable, not a pointer to it
+									if kind := (&column{goType: col.ArgType()}).ValueKind(); col.nulls && kind != "" && !col.customType {
+										fmt.Println(processFail + "The variable " + col.varName + " can't be [nulls] or a pointer: arrays, maps, json.RawMessage, time.Duration and net.IP aren't supported as nullable types.")
+										return
+									}
 
-									if col.nulls {
-										if err := col.MapNullTypes(); err != nil {
+									//a custom type's own Scan and Value handle NULL, so it keeps its type with [nulls]
+									if col.nulls && !col.customType && col.nullStyle == "" {
+										if err := col.MapNullTypes(nullsStyle); err != nil {
 											fmt.Println(processFail + err.Error() + "\n")
 											return
 										}
@@ -533,12 +866,37 @@ func main() {
 				dbGroup = dbUser
 			}
 
+			//Link [references] columns to the structs and tables they point to
+			if err := ResolveReferences(structsToAdd); err != nil {
+				fmt.Println(processFail + err.Error())
+				return
+			}
+
+			//Link [many to many] lines to their structs
+			if err := ResolveJoins(structsToAdd, joins); err != nil {
+				fmt.Println(processFail + err.Error())
+				return
+			}
+			if err := CheckEnums(enums); err != nil {
+				fmt.Println(processFail + err.Error())
+				return
+			}
+			if err := CheckNestedUsed(nestedStructs); err != nil {
+				fmt.Println(processFail + err.Error())
+				return
+			}
+
 			//Cycle through structsToAdd
 			fileOpen := make(map[string]*os.File)
 			pathChanged := make(map[string]string)
 			connString := BuildConnString(dbUser, password, dbName, server, useSSL)
 			dbConnected := false
 			var db *sql.DB
+			var tablesCreated []*structToCreate
+			fileStructs := make(map[string][]*structToCreate)
+			for _, structObj := range structsToAdd {
+				fileStructs[structObj.fileName] = append(fileStructs[structObj.fileName], structObj)
+			}
 			for _, structObj := range structsToAdd {
 				if pathChanged[structObj.fileName] == "" {
 					//New path, check to make sure it doesn't already exist
@@ -552,13 +910,10 @@ func main() {
 						return
 					}
 
-					//BuildStringForFileWrite(structObj, true, packageName)
-					fileOpen[pathChanged[structObj.fileName]].WriteString(BuildStringForFileWrite(structObj, true, packageName))
-				} else {
-					//file exists so append
-					fileOpen[pathChanged[structObj.fileName]].WriteString(BuildStringForFileWrite(structObj, false, packageName))
-
+					//imports cover every struct that will be written to the file
+					fileOpen[pathChanged[structObj.fileName]].WriteString(BuildFileHeader(fileStructs[structObj.fileName], packageName))
 				}
+				fileOpen[pathChanged[structObj.fileName]].WriteString(BuildStringForFileWrite(structObj))
 				fileOpen[pathChanged[structObj.fileName]].Sync()
 
 				//Check to see if user wants to generate or alter tables
@@ -585,9 +940,40 @@ func main() {
 						}
 					}
 					CreateOrAlterTables(structObj, db, dbGroup)
+					tablesCreated = append(tablesCreated, structObj)
 				}
 
 			} //end range structsToAdd
+			//Foreign keys are added once all of the tables exist, referenced tables first
+			for _, structObj := range SortStructsByReferences(tablesCreated) {
+				AddForeignKeys(structObj, db)
+			}
+			//Join tables need both of their tables, so they come last
+			for _, join := range joins {
+				var yesOrNo string
+				fmt.Printf("\n\nDo you want to create the join table %s (y or n): ", join.tableName)
+				_, err := fmt.Scanf("%s", &yesOrNo)
+				if err != nil {
+					fmt.Println("An error occurred, exiting Street CRUD.")
+					return
+				}
+				if strings.ToLower(yesOrNo) == "y" || strings.ToLower(yesOrNo) == "yes" {
+					if dbConnected == false {
+						dbConnected = true
+						var err error
+						db, err = sql.Open("postgres", connString)
+						if err != nil {
+							fmt.Printf("\nThere was a problem opening the database: %s", err.Error()+"\n")
+							return
+						}
+						if err := db.Ping(); err != nil {
+							fmt.Printf("\nDB connection issue: %s", err.Error()+"\n")
+							return
+						}
+					}
+					CreateJoinTable(join, db, dbGroup)
+				}
+			}
 			if dbConnected {
 				db.Close()
 			}
@@ -607,6 +993,10 @@ func main() {
 		packageName = ""
 		reqVarCount = 0
 		structsToAdd = nil
+		joins = nil
+		enums = nil
+		nestedStructs = nil
+		nullsStyle = "nulls"
 		structFromFile = nil
 		filePath = ""
 		isFileFound = false
@@ -43,6 +43,15 @@ func ConvertToUnderscore(camel string) (string, error) {
 	return string(underscore), nil
 }
 
+// ConvertToCamel turns an underscore name into CamelCase, e.g. on_hold into OnHold
+func ConvertToCamel(underscore string) string {
+	var camel strings.Builder
+	for _, part := range strings.Split(underscore, "_") {
+		camel.WriteString(UpperCaseFirstChar(part))
+	}
+	return camel.String()
+}
+
 func UpperCaseFirstChar(word string) string {
 	runes := []rune(word)
 	if len(runes) > 0 {